@@ -0,0 +1,261 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// sample represents the TCP_INFO of a bulk transfer at a point
+// in time, the time is microseconds since the transfer started
+type sample struct {
+	Time         int64
+	Bytes        int64
+	Rtt          uint32
+	Rttvar       uint32
+	SndCwnd      uint32
+	TotalRetrans uint32
+	DeliveryRate uint64
+}
+
+// bulkLimiter ends the transfer once the given size
+// has been transferred or the deadline has passed, and
+// it pushes the connection's idle deadline on each read
+type bulkLimiter struct {
+	r        io.Reader
+	n        *int64
+	size     int64
+	deadline time.Time
+	conn     net.Conn
+	idle     time.Duration
+}
+
+// zeroReader generates the upload data
+type zeroReader struct{}
+
+func (c *client) isBulk() bool {
+	return c.req.bulkDuration > 0 || c.req.bulkSize > 0
+}
+
+// bulk downloads or uploads a large object over the connection
+// and samples the TCP_INFO while the transfer is in progress
+func (c *client) bulk() error {
+	var (
+		n    int64
+		err  error
+		wg   sync.WaitGroup
+		done = make(chan struct{})
+	)
+
+	c.samples = c.samples[:0]
+
+	start := time.Now()
+	limiter := &bulkLimiter{n: &n, size: c.req.bulkSize, conn: c.conn, idle: c.req.timeout}
+	if c.req.bulkDuration > 0 {
+		limiter.deadline = start.Add(c.req.bulkDuration)
+	}
+	defer c.conn.SetDeadline(time.Time{})
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		c.sampleTCPInfo(start, &n, done)
+	}()
+
+	switch c.scheme() {
+	case "http", "https":
+		err = c.bulkHTTP(limiter)
+	default:
+		err = c.bulkTCP(limiter)
+	}
+
+	close(done)
+	wg.Wait()
+
+	c.setBulkStats(atomic.LoadInt64(&n), time.Since(start))
+
+	if err != nil {
+		return fmt.Errorf("%s bulk transfer: %v", c.target, err)
+	}
+
+	return nil
+}
+
+func (c *client) bulkHTTP(limiter *bulkLimiter) error {
+	// the HTTP timeout only applies to the response headers, the
+	// transfer has no limit and a stalled peer hits the idle deadline
+	httpClient := &http.Client{
+		Transport: &http.Transport{
+			DialContext:           c.dialContext,
+			DialTLSContext:        c.dialTLSContext,
+			ResponseHeaderTimeout: c.req.timeoutHTTP,
+		},
+		CheckRedirect: c.noRedirect,
+	}
+
+	method := c.req.httpMethod
+	if c.req.bulkUpload && (method == "" || method == http.MethodGet) {
+		method = http.MethodPost
+	}
+
+	req, err := c.newHTTPRequest(method, c.target, nil)
+	if err != nil {
+		return err
+	}
+
+	c.dials = 0
+
+	if c.req.bulkUpload {
+		limiter.r = zeroReader{}
+		req.Body = ioutil.NopCloser(limiter)
+		req.ContentLength = c.req.bulkSize
+		if c.req.bulkDuration > 0 {
+			req.ContentLength = -1
+		}
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	// closing the partially read body closes the connection
+	// so it's closed once the TCP_INFO has been read
+	c.closers = append(c.closers, resp.Body)
+
+	c.stats.HTTPStatusCode = resp.StatusCode
+	c.stats.HTTPProto = resp.Proto
+
+	if c.req.bulkUpload {
+		_, err = io.Copy(ioutil.Discard, resp.Body)
+		return err
+	}
+
+	limiter.r = resp.Body
+	_, err = io.Copy(ioutil.Discard, limiter)
+
+	return err
+}
+
+// bulkTCP sends the payload if there is any, e.g. a download
+// request, then it reads from or writes to the connection
+func (c *client) bulkTCP(limiter *bulkLimiter) error {
+	if c.hasPayload() {
+		if _, err := c.conn.Write(c.req.payload); err != nil {
+			return err
+		}
+	}
+
+	if c.req.bulkUpload {
+		limiter.r = zeroReader{}
+		_, err := io.Copy(c.conn, limiter)
+		return err
+	}
+
+	limiter.r = c.conn
+	_, err := io.Copy(ioutil.Discard, limiter)
+
+	return err
+}
+
+// sampleTCPInfo samples the TCP_INFO at the configured interval
+// until the done channel is closed
+func (c *client) sampleTCPInfo(start time.Time, n *int64, done chan struct{}) {
+	if c.req.bulkInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(c.req.bulkInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case t := <-ticker.C:
+			var s stats
+			if err := readTCPInfo(c.conn, &s); err != nil {
+				continue
+			}
+
+			c.samples = append(c.samples, sample{
+				Time:         t.Sub(start).Microseconds(),
+				Bytes:        atomic.LoadInt64(n),
+				Rtt:          s.Rtt,
+				Rttvar:       s.Rttvar,
+				SndCwnd:      s.SndCwnd,
+				TotalRetrans: s.TotalRetrans,
+				DeliveryRate: s.DeliveryRate,
+			})
+		}
+	}
+}
+
+func (c *client) setBulkStats(n int64, d time.Duration) {
+	c.stats.BulkBytes = n
+	c.stats.BulkDuration = d.Microseconds()
+	c.stats.BulkThroughput = 0
+	c.stats.BulkRttMin = 0
+	c.stats.BulkRttMax = 0
+	c.stats.BulkSndCwndMax = 0
+
+	if c.stats.BulkDuration > 0 {
+		c.stats.BulkThroughput = n * int64(time.Second/time.Microsecond) / c.stats.BulkDuration
+	}
+
+	for i, s := range c.samples {
+		if i == 0 || s.Rtt < c.stats.BulkRttMin {
+			c.stats.BulkRttMin = s.Rtt
+		}
+		if s.Rtt > c.stats.BulkRttMax {
+			c.stats.BulkRttMax = s.Rtt
+		}
+		if s.SndCwnd > c.stats.BulkSndCwndMax {
+			c.stats.BulkSndCwndMax = s.SndCwnd
+		}
+	}
+}
+
+func (b *bulkLimiter) Read(p []byte) (int, error) {
+	if b.size > 0 {
+		remain := b.size - atomic.LoadInt64(b.n)
+		if remain <= 0 {
+			return 0, io.EOF
+		}
+		if int64(len(p)) > remain {
+			p = p[:remain]
+		}
+	}
+
+	now := time.Now()
+	if !b.deadline.IsZero() && now.After(b.deadline) {
+		return 0, io.EOF
+	}
+
+	// a stalled peer fails the transfer after the idle timeout
+	// unless the duration has been reached in the meantime
+	if b.idle > 0 {
+		b.conn.SetDeadline(now.Add(b.idle))
+	}
+
+	n, err := b.r.Read(p)
+	atomic.AddInt64(b.n, int64(n))
+
+	if e, ok := err.(net.Error); ok && e.Timeout() && !b.deadline.IsZero() && !time.Now().Before(b.deadline) {
+		return n, io.EOF
+	}
+
+	return n, err
+}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+
+	return len(p), nil
+}
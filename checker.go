@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// checker represents an application protocol health check
+// which runs over the established connection
+type checker interface {
+	// check returns the server's version if the protocol exposes it
+	check(conn net.Conn, u *url.URL) (string, error)
+}
+
+type redisChecker struct{}
+type mysqlChecker struct{}
+type postgresChecker struct{}
+type sshChecker struct{}
+
+// checkers represents the available checkers by URL scheme
+var checkers = map[string]checker{
+	"redis":    redisChecker{},
+	"mysql":    mysqlChecker{},
+	"postgres": postgresChecker{},
+	"ssh":      sshChecker{},
+}
+
+func (c *client) getChecker() checker {
+	return checkers[c.scheme()]
+}
+
+func (c *client) protoCheck(chk checker) error {
+	c.stats.ProtoCheck = 0
+	c.stats.ProtoCheckSuccess = 0
+	c.stats.ProtoServerVersion = ""
+
+	c.conn.SetDeadline(time.Now().Add(c.req.timeout))
+	defer c.conn.SetDeadline(time.Time{})
+
+	t := time.Now()
+	version, err := chk.check(c.conn, c.urlSchema)
+	if err != nil {
+		return fmt.Errorf("%s %s check: %v", c.target, c.scheme(), err)
+	}
+
+	c.stats.ProtoCheck = time.Since(t).Microseconds()
+	c.stats.ProtoCheckSuccess = 1
+	c.stats.ProtoServerVersion = version
+
+	return nil
+}
+
+// check sends PING and expects PONG, it authenticates
+// first if the password is given, e.g. redis://:pass@host
+func (redisChecker) check(conn net.Conn, u *url.URL) (string, error) {
+	r := bufio.NewReader(conn)
+
+	if pass, ok := u.User.Password(); ok {
+		args := []string{"AUTH", pass}
+		if user := u.User.Username(); user != "" {
+			args = []string{"AUTH", user, pass}
+		}
+
+		if err := redisCmd(conn, r, "+OK", args...); err != nil {
+			return "", err
+		}
+	}
+
+	return "", redisCmd(conn, r, "+PONG", "PING")
+}
+
+func redisCmd(conn net.Conn, r *bufio.Reader, expect string, args ...string) error {
+	cmd := fmt.Sprintf("*%d\r\n", len(args))
+	for _, arg := range args {
+		cmd += fmt.Sprintf("$%d\r\n%s\r\n", len(arg), arg)
+	}
+
+	if _, err := conn.Write([]byte(cmd)); err != nil {
+		return err
+	}
+
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return err
+	}
+
+	if strings.TrimSpace(line) != expect {
+		return fmt.Errorf("unexpected response: %s", strings.TrimSpace(line))
+	}
+
+	return nil
+}
+
+// check parses the server's initial handshake packet
+func (mysqlChecker) check(conn net.Conn, u *url.URL) (string, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return "", err
+	}
+
+	payload := make([]byte, int(header[0])|int(header[1])<<8|int(header[2])<<16)
+	if _, err := io.ReadFull(conn, payload); err != nil {
+		return "", err
+	}
+
+	switch {
+	case len(payload) > 3 && payload[0] == 0xff:
+		return "", fmt.Errorf("server error: %s", payload[3:])
+	case len(payload) < 2 || payload[0] != 10:
+		return "", fmt.Errorf("unexpected protocol version")
+	}
+
+	i := bytes.IndexByte(payload[1:], 0)
+	if i < 0 {
+		return "", fmt.Errorf("invalid server version")
+	}
+
+	return string(payload[1 : i+1]), nil
+}
+
+// check sends the SSLRequest and then the StartupMessage if the server
+// doesn't support SSL, any authentication or error response means it's alive
+func (postgresChecker) check(conn net.Conn, u *url.URL) (string, error) {
+	b := make([]byte, 1)
+
+	if _, err := conn.Write([]byte{0, 0, 0, 8, 0x04, 0xd2, 0x16, 0x2f}); err != nil {
+		return "", err
+	}
+
+	if _, err := io.ReadFull(conn, b); err != nil {
+		return "", err
+	}
+
+	switch b[0] {
+	case 'S':
+		return "", nil
+	case 'N':
+	default:
+		return "", fmt.Errorf("unexpected SSLRequest response: %q", b[0])
+	}
+
+	user := u.User.Username()
+	if user == "" {
+		user = "tcpprobe"
+	}
+
+	params := "user\x00" + user + "\x00database\x00" + user + "\x00\x00"
+	msg := make([]byte, 8, 8+len(params))
+	binary.BigEndian.PutUint32(msg[0:], uint32(8+len(params)))
+	binary.BigEndian.PutUint32(msg[4:], 196608) // protocol 3.0
+	msg = append(msg, params...)
+
+	if _, err := conn.Write(msg); err != nil {
+		return "", err
+	}
+
+	if _, err := io.ReadFull(conn, b); err != nil {
+		return "", err
+	}
+
+	if b[0] != 'R' && b[0] != 'E' {
+		return "", fmt.Errorf("unexpected StartupMessage response: %q", b[0])
+	}
+
+	return "", nil
+}
+
+// check reads the server's identification string
+func (sshChecker) check(conn net.Conn, u *url.URL) (string, error) {
+	r := bufio.NewReaderSize(conn, 256)
+
+	// the server may send other lines before the version
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+
+		if strings.HasPrefix(line, "SSH-") {
+			return strings.TrimSpace(line), nil
+		}
+	}
+}
@@ -1,10 +1,14 @@
 package main
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"io"
+	"net/http"
 	"reflect"
+	"regexp"
 	"strings"
 	"text/template"
 	"time"
@@ -18,18 +22,26 @@ type request struct {
 	ipv4         bool
 	ipv6         bool
 	http2        bool
+	http3        bool
 	k8s          bool
 	json         bool
 	jsonPretty   bool
 	grpc         bool
 	quiet        bool
 	insecure     bool
+	responder    bool
+	pmtu         bool
+	persistent   bool
+	mptcp        bool
+	ecn          bool
 	promDisabled bool
 	grpcAddr     string
 	namespace    string
 	promAddr     string
 	serverName   string
 	srcAddr      string
+	netns        string
+	starttls     string
 	config       string
 	filter       map[string]struct{}
 
@@ -40,14 +52,49 @@ type request struct {
 	soSndBuf      int
 	soRcvBuf      int
 	soCongestion  string
+	soInterface   string
+	soMark        int
 	soTCPNoDelay  bool
 	soTCPQuickACK bool
+	soTCPFastOpen bool
+
+	keepAliveIdle  time.Duration
+	keepAliveIntvl time.Duration
+	keepAliveCnt   int
+	userTimeout    time.Duration
+
+	tlsCerts   []tls.Certificate
+	tlsRootCAs *x509.CertPool
+
+	httpMethod  string
+	httpHeaders http.Header
+	httpBody    []byte
+	httpStatus  statusRanges
+
+	httpBodyContains string
+	httpBodyRegex    *regexp.Regexp
+	httpBodyJSON     *jsonCheck
+
+	followRedirects int
+	wsPings         int
+
+	bulkDuration time.Duration
+	bulkInterval time.Duration
+	bulkSize     int64
+	bulkUpload   bool
+
+	payload     []byte
+	expectDelim []byte
+	expectRegex *regexp.Regexp
+	expectBytes int
 
 	timeout     time.Duration
 	timeoutHTTP time.Duration
 	interval    time.Duration
 
-	cmd *cmdReq
+	cmd   *cmdReq
+	serve *serveReq
+	trace *traceReq
 
 	checkUpdate bool
 }
@@ -75,15 +122,62 @@ func getCli(args []string) (*request, []string, error) {
 		&cli.BoolFlag{Name: "insecure", Value: true, Usage: "don't validate the server's certificate"},
 	}
 
+	serveFlags := []cli.Flag{
+		&cli.StringFlag{Name: "addr", Aliases: []string{"d"}, Value: ":8083", Usage: "specify the listen IP and port"},
+		&cli.BoolFlag{Name: "http", Usage: "serve the HTTP endpoints instead of the line based commands"},
+		&cli.StringFlag{Name: "cert", Usage: "server certificate file (PEM) to enable TLS"},
+		&cli.StringFlag{Name: "key", Usage: "server private key file (PEM)"},
+		&cli.BoolFlag{Name: "quiet", Aliases: []string{"q"}, Usage: "turn off the connections TCP_INFO output"},
+		&cli.BoolFlag{Name: "mptcp", Usage: "accept Multipath TCP connections"},
+		&cli.DurationFlag{Name: "max-duration", Value: time.Minute, Usage: "maximum duration of an HTTP download or upload [0 is unlimited]"},
+	}
+
+	traceFlags := []cli.Flag{
+		&cli.IntFlag{Name: "max-hops", Aliases: []string{"m"}, Value: 30, Usage: "maximum number of hops"},
+		&cli.DurationFlag{Name: "timeout", Aliases: []string{"t"}, Value: time.Second, Usage: "specify a timeout for each hop"},
+		&cli.StringFlag{Name: "source-addr", Aliases: []string{"S"}, Usage: "source address in outgoing request"},
+		&cli.BoolFlag{Name: "ipv6", Aliases: []string{"6"}, Usage: "connect only to IPv6 address"},
+		&cli.BoolFlag{Name: "ipv4", Aliases: []string{"4"}, Usage: "connect only to IPv4 address"},
+	}
+
 	flags := []cli.Flag{
 		&cli.BoolFlag{Name: "ipv6", Aliases: []string{"6"}, Usage: "connect only to IPv6 address"},
 		&cli.BoolFlag{Name: "ipv4", Aliases: []string{"4"}, Usage: "connect only to IPv4 address"},
 		&cli.IntFlag{Name: "count", Aliases: []string{"c"}, Value: 0, Usage: "stop after sending count requests [0 is unlimited]"},
-		&cli.BoolFlag{Name: "http2", Usage: "force to use HTTP version 2"},
+		&cli.BoolFlag{Name: "http2", Usage: "force to use HTTP version 2, cleartext (h2c) for http targets and HTTP/1.1 if a TLS server doesn't negotiate h2"},
+		&cli.BoolFlag{Name: "http3", Usage: "use HTTP version 3 (QUIC) for https targets"},
+		&cli.StringFlag{Name: "http-method", Aliases: []string{"X"}, Value: "GET", Usage: "HTTP request method"},
+		&cli.StringSliceFlag{Name: "http-header", Aliases: []string{"H"}, Usage: "HTTP request header in \"key: value\" format"},
+		&cli.StringFlag{Name: "http-body", Usage: "HTTP request body"},
+		&cli.StringFlag{Name: "http-body-file", Usage: "read the HTTP request body from the given file"},
+		&cli.StringFlag{Name: "http-expect-status", Usage: "expected HTTP status code(s) or range(s), e.g. 200,300-399"},
+		&cli.StringFlag{Name: "http-body-contains", Usage: "expected substring in the HTTP response body"},
+		&cli.StringFlag{Name: "http-body-regex", Usage: "expected regular expression match in the HTTP response body"},
+		&cli.StringFlag{Name: "http-body-json", Usage: "expected JSON value in the HTTP response body, e.g. data.status=ok"},
+		&cli.IntFlag{Name: "follow-redirects", Aliases: []string{"L"}, Value: 0, Usage: "follow up to N HTTP redirects [0 is disabled]"},
 		&cli.BoolFlag{Name: "prom-disabled", Usage: "disable prometheus"},
 		&cli.BoolFlag{Name: "insecure", Usage: "don't validate the server's certificate"},
+		&cli.BoolFlag{Name: "responder", Usage: "fetch the server side TCP_INFO from the tcpprobe responder target"},
+		&cli.BoolFlag{Name: "pmtu", Usage: "probe the path MTU with single DF segments through an echo service or the tcpprobe responder"},
+		&cli.StringFlag{Name: "cert", Usage: "client certificate file (PEM)"},
+		&cli.StringFlag{Name: "key", Usage: "client private key file (PEM)"},
+		&cli.StringFlag{Name: "cacert", Usage: "CA certificate bundle file (PEM) to verify the server"},
+		&cli.DurationFlag{Name: "bulk-duration", Usage: "transfer a large object for the given duration and sample TCP_INFO"},
+		&cli.Int64Flag{Name: "bulk-size", Usage: "transfer a large object up to the given bytes and sample TCP_INFO"},
+		&cli.BoolFlag{Name: "bulk-upload", Usage: "upload instead of download in the bulk transfer"},
+		&cli.DurationFlag{Name: "bulk-sample-interval", Value: 100 * time.Millisecond, Usage: "TCP_INFO sample interval in the bulk transfer"},
+		&cli.IntFlag{Name: "ws-pings", Value: 3, Usage: "number of WebSocket ping frames"},
+		&cli.StringFlag{Name: "payload", Usage: "send the payload to a TCP/TLS target, e.g. \"PING\\r\\n\""},
+		&cli.StringFlag{Name: "payload-hex", Usage: "send the hex encoded payload to a TCP/TLS target"},
+		&cli.StringFlag{Name: "expect-delim", Usage: "read the response until the delimiter, e.g. \"\\r\\n\""},
+		&cli.StringFlag{Name: "expect-regex", Usage: "read the response until the regular expression matches"},
+		&cli.IntFlag{Name: "expect-bytes", Usage: "read the response until the given number of bytes"},
+		&cli.StringFlag{Name: "starttls", Usage: "upgrade to TLS through STARTTLS: smtp, imap, pop3, ftp or postgres"},
 		&cli.StringFlag{Name: "server-name", Aliases: []string{"n"}, Usage: "server name is used to verify the hostname (TLS)"},
-		&cli.StringFlag{Name: "source-addr", Aliases: []string{"S"}, Usage: "source address in outgoing request"},
+		&cli.StringFlag{Name: "source-addr", Aliases: []string{"S"}, Usage: "source address or interface name in outgoing request"},
+		&cli.StringFlag{Name: "interface", Aliases: []string{"I"}, Usage: "bind the socket to the interface or VRF device (SO_BINDTODEVICE)"},
+		&cli.IntFlag{Name: "fwmark", Usage: "set the firewall mark of the outgoing packets (SO_MARK)"},
+		&cli.StringFlag{Name: "netns", Usage: "network namespace name under /var/run/netns or path, e.g. /proc/<pid>/ns/net"},
 		&cli.StringFlag{Name: "prom-addr", Aliases: []string{"p"}, Value: ":8081", Usage: "specify prometheus exporter IP and port"},
 		&cli.StringFlag{Name: "filter", Aliases: []string{"f"}, Usage: "given metric(s) with semicolon delimited"},
 		&cli.DurationFlag{Name: "timeout", Aliases: []string{"t"}, Value: 5 * time.Second, Usage: "specify a timeout for dialing to targets"},
@@ -98,6 +192,14 @@ func getCli(args []string) (*request, []string, error) {
 		&cli.IntFlag{Name: "rcvd-buffer", Aliases: []string{}, DefaultText: "depends on the OS", Usage: "maximum socket receive buffer in bytes"},
 		&cli.BoolFlag{Name: "tcp-nodelay-disabled", Aliases: []string{"o"}, Usage: "disable Nagle's algorithm"},
 		&cli.BoolFlag{Name: "tcp-quickack-disabled", Aliases: []string{"k"}, Usage: "disable quickack mode"},
+		&cli.BoolFlag{Name: "ecn", Usage: "fail the probe if ECN isn't requested, it needs net.ipv4.tcp_ecn=1 or an ECN congestion control e.g. dctcp"},
+		&cli.BoolFlag{Name: "mptcp", Usage: "enable Multipath TCP, it falls back to TCP if the server doesn't support it"},
+		&cli.BoolFlag{Name: "persistent", Usage: "keep the connection open and sample its TCP_INFO at each interval, it doesn't apply to HTTP/3"},
+		&cli.DurationFlag{Name: "keepalive-idle", Usage: "idle time before the first TCP keepalive probe (TCP_KEEPIDLE)"},
+		&cli.DurationFlag{Name: "keepalive-interval", Usage: "time between the TCP keepalive probes (TCP_KEEPINTVL)"},
+		&cli.IntFlag{Name: "keepalive-count", Usage: "unanswered TCP keepalive probes before dropping the connection (TCP_KEEPCNT)"},
+		&cli.DurationFlag{Name: "user-timeout", Usage: "maximum time the transmitted data may remain unacknowledged (TCP_USER_TIMEOUT)"},
+		&cli.BoolFlag{Name: "tcp-fastopen", Usage: "enable TCP Fast Open for the exchanges that the client writes first, the SYN carries the first write's data once the cookie is cached"},
 		&cli.BoolFlag{Name: "k8s", Usage: "enable k8s"},
 		&cli.StringFlag{Name: "namespace", Value: "default", Usage: "kubernetes namespace"},
 		&cli.BoolFlag{Name: "quiet", Aliases: []string{"q"}, Usage: "turn off tcpprobe output"},
@@ -157,6 +259,48 @@ func getCli(args []string) (*request, []string, error) {
 						return errors.New("configuration not specified")
 					}
 
+					return nil
+				},
+			},
+			{
+				Name:        "serve",
+				Usage:       "run tcpprobe server as the probes responder",
+				Description: serveDescription,
+				Flags:       serveFlags,
+				Action: func(c *cli.Context) error {
+					r.serve = &serveReq{
+						addr:        c.String("addr"),
+						http:        c.Bool("http"),
+						cert:        c.String("cert"),
+						key:         c.String("key"),
+						quiet:       c.Bool("quiet"),
+						mptcp:       c.Bool("mptcp"),
+						maxDuration: c.Duration("max-duration"),
+					}
+
+					return nil
+				},
+			},
+			{
+				Name:      "trace",
+				Usage:     "trace the path to the target's TCP port",
+				UsageText: "tcpprobe trace [command options] target(s)",
+				Flags:     traceFlags,
+				Action: func(c *cli.Context) error {
+					r.trace = &traceReq{
+						maxHops: c.Int("max-hops"),
+						timeout: c.Duration("timeout"),
+						srcAddr: c.String("source-addr"),
+						ipv4:    c.Bool("ipv4"),
+						ipv6:    c.Bool("ipv6"),
+					}
+
+					targets = c.Args().Slice()
+					if len(targets) < 1 {
+						cli.ShowCommandHelp(c, "trace")
+						return errors.New("target not specified")
+					}
+
 					return nil
 				},
 			},
@@ -166,36 +310,89 @@ func getCli(args []string) (*request, []string, error) {
 				ipv4:         c.Bool("ipv4"),
 				ipv6:         c.Bool("ipv6"),
 				http2:        c.Bool("http2"),
+				http3:        c.Bool("http3"),
 				k8s:          c.Bool("k8s"),
 				json:         c.Bool("json"),
 				jsonPretty:   c.Bool("json-pretty"),
 				grpc:         c.Bool("grpc"),
 				quiet:        c.Bool("quiet"),
 				insecure:     c.Bool("insecure"),
+				responder:    c.Bool("responder"),
+				pmtu:         c.Bool("pmtu"),
+				persistent:   c.Bool("persistent"),
+				mptcp:        c.Bool("mptcp"),
+				ecn:          c.Bool("ecn"),
 				promDisabled: c.Bool("prom-disabled"),
 				namespace:    c.String("namespace"),
 				promAddr:     c.String("prom-addr"),
 				grpcAddr:     c.String("grpc-addr"),
 				serverName:   c.String("server-name"),
 				srcAddr:      c.String("source-addr"),
+				netns:        c.String("netns"),
+				starttls:     strings.ToLower(c.String("starttls")),
 				config:       c.String("config"),
 				count:        c.Int("count"),
+				wsPings:      c.Int("ws-pings"),
+				bulkDuration: c.Duration("bulk-duration"),
+				bulkInterval: c.Duration("bulk-sample-interval"),
+				bulkSize:     c.Int64("bulk-size"),
+				bulkUpload:   c.Bool("bulk-upload"),
 				filter:       filterMap(c.String("filter")),
 
-				soIPTOS:      c.Int("tos"),
-				soIPTTL:      c.Int("ttl"),
-				soPriority:   c.Int("socket-priority"),
-				soMaxSegSize: c.Int("mss"),
-				soSndBuf:     c.Int("send-buffer"),
-				soRcvBuf:     c.Int("rcvd-buffer"),
-				soCongestion: c.String("congestion-alg"),
-				soTCPNoDelay: c.Bool("tcp-nodelay-disabled"),
+				soIPTOS:       c.Int("tos"),
+				soIPTTL:       c.Int("ttl"),
+				soPriority:    c.Int("socket-priority"),
+				soMaxSegSize:  c.Int("mss"),
+				soSndBuf:      c.Int("send-buffer"),
+				soRcvBuf:      c.Int("rcvd-buffer"),
+				soCongestion:  c.String("congestion-alg"),
+				soInterface:   c.String("interface"),
+				soMark:        c.Int("fwmark"),
+				soTCPNoDelay:  c.Bool("tcp-nodelay-disabled"),
+				soTCPFastOpen: c.Bool("tcp-fastopen"),
+
+				keepAliveIdle:  c.Duration("keepalive-idle"),
+				keepAliveIntvl: c.Duration("keepalive-interval"),
+				keepAliveCnt:   c.Int("keepalive-count"),
+				userTimeout:    c.Duration("user-timeout"),
 
 				interval:    c.Duration("interval"),
 				timeout:     c.Duration("timeout"),
 				timeoutHTTP: c.Duration("http-timeout"),
 			}
 
+			if err := r.setTLS(c.String("cert"), c.String("key"), c.String("cacert")); err != nil {
+				return err
+			}
+
+			payloadCfg := &payloadConfig{
+				Send:        c.String("payload"),
+				SendHex:     c.String("payload-hex"),
+				ExpectDelim: c.String("expect-delim"),
+				ExpectRegex: c.String("expect-regex"),
+				ExpectBytes: c.Int("expect-bytes"),
+			}
+
+			if err := r.setPayload(payloadCfg); err != nil {
+				return err
+			}
+
+			httpCfg := &httpConfig{
+				Method:       c.String("http-method"),
+				Body:         c.String("http-body"),
+				BodyFile:     c.String("http-body-file"),
+				ExpectStatus: c.String("http-expect-status"),
+				BodyContains: c.String("http-body-contains"),
+				BodyRegex:    c.String("http-body-regex"),
+				BodyJSON:     c.String("http-body-json"),
+
+				FollowRedirects: c.Int("follow-redirects"),
+			}
+
+			if err := r.setHTTP(httpCfg, c.StringSlice("http-header")); err != nil {
+				return err
+			}
+
 			if c.Bool("metrics") {
 				fmt.Println("metrics:")
 				v := reflect.ValueOf(&stats{}).Elem()
@@ -239,6 +436,23 @@ examples:
    tcpprobe -json -c 0 https://www.google.com
    tcpprobe -filter "Rtt;TCPConnect" https://www.yahoo.com
    tcpprobe smtp.gmail.com:587
+   tcpprobe -starttls smtp smtp.gmail.com:587
+   tcpprobe tls://ldap.example.com:636
+   tcpprobe -payload "PING\r\n" -expect-delim "\r\n" 127.0.0.1:6379
+   tcpprobe redis://127.0.0.1:6379
+   tcpprobe grpc://127.0.0.1:50051/myservice
+   tcpprobe -ws-pings 5 wss://echo.example.com/ws
+   tcpprobe -http3 https://www.google.com
+   tcpprobe trace www.google.com:443
+   tcpprobe -responder -payload "echo ping\n" -expect-delim "\n" 192.168.10.1:8083
+   tcpprobe -pmtu -responder 192.168.10.1:8083
+   tcpprobe -tcp-fastopen -c 3 http://192.168.10.1
+   tcpprobe -netns blue 10.0.0.1:80
+   tcpprobe -mptcp -persistent 10.0.0.1:8083
+   tcpprobe -ecn -congestion-alg dctcp 10.0.0.1:80
+   tcpprobe -persistent -i 30s -keepalive-idle 60s -keepalive-interval 10s -keepalive-count 3 10.0.0.1:22
+   tcpprobe -interface eth1 -fwmark 100 -source-addr eth1 10.0.0.1:80
+   tcpprobe -bulk-duration 10s -congestion-alg bbr https://speed.example.com/10GB.bin
 
 for more information: https://github.com/mehrdadrad/tcpprobe/wiki   
 `
@@ -261,6 +475,71 @@ for more information: https://github.com/mehrdadrad/tcpprobe/wiki
 	return r, targets, err
 }
 
+// setHTTP parses and sets the HTTP request's parameters, it
+// overrides only the given ones. the headers are in "key: value" format
+func (r *request) setHTTP(cfg *httpConfig, headers []string) error {
+	if cfg.Method != "" {
+		r.httpMethod = strings.ToUpper(cfg.Method)
+	}
+
+	if len(headers) > 0 {
+		h, err := parseHTTPHeaders(headers)
+		if err != nil {
+			return err
+		}
+
+		r.httpHeaders = r.httpHeaders.Clone()
+		if r.httpHeaders == nil {
+			r.httpHeaders = http.Header{}
+		}
+		for k, v := range h {
+			r.httpHeaders[k] = v
+		}
+	}
+
+	if cfg.Body != "" || cfg.BodyFile != "" {
+		b, err := getHTTPBody(cfg.Body, cfg.BodyFile)
+		if err != nil {
+			return err
+		}
+		r.httpBody = b
+	}
+
+	if cfg.ExpectStatus != "" {
+		s, err := parseHTTPStatus(cfg.ExpectStatus)
+		if err != nil {
+			return err
+		}
+		r.httpStatus = s
+	}
+
+	if cfg.BodyContains != "" {
+		r.httpBodyContains = cfg.BodyContains
+	}
+
+	if cfg.BodyRegex != "" {
+		re, err := regexp.Compile(cfg.BodyRegex)
+		if err != nil {
+			return err
+		}
+		r.httpBodyRegex = re
+	}
+
+	if cfg.BodyJSON != "" {
+		j, err := parseJSONCheck(cfg.BodyJSON)
+		if err != nil {
+			return err
+		}
+		r.httpBodyJSON = j
+	}
+
+	if cfg.FollowRedirects > 0 {
+		r.followRedirects = cfg.FollowRedirects
+	}
+
+	return nil
+}
+
 func filterMap(s string) map[string]struct{} {
 	m := map[string]struct{}{}
 	if len(s) < 1 {
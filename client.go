@@ -7,7 +7,6 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
@@ -69,7 +68,7 @@ type stats struct {
 	RwndLimited   uint64  `name:"tcpinfo_rwnd_limited" help:"time (usec) limited by receive window"`
 	SndbufLimited uint64  `name:"tcpinfo_sndbuf_limited" help:"time (usec) limited by send buffer"`
 	Delivered     uint32  `name:"tcpinfo_delivered" help:""`
-	DeliveredCe   uint32  `name:"tcpinfo_delivered_ce" help:""`
+	DeliveredCe   uint32  `name:"tcpinfo_delivered_ce" help:"packets delivered with the ECN CE mark"`
 	BytesSent     uint64  `name:"tcpinfo_bytes_sent" help:""`
 	BytesRetrans  uint64  `name:"tcpinfo_bytes_retrans" help:"RFC4898 tcpEStatsPerfOctetsRetrans"`
 	DsackDups     uint32  `name:"tcpinfo_dsack_dups" help:"RFC4898 tcpEStatsStackDSACKDups"`
@@ -78,18 +77,131 @@ type stats struct {
 	SndWnd        uint32  `name:"tcpinfo_snd_wnd" help:""`
 
 	TCPCongesAlg string `help:"TCP network congestion-avoidance algorithm"`
+	TCPOptions   string `name:"options" info:"tcp" help:"negotiated TCP options"`
+
+	ECNRequested  uint8 `name:"ecn_requested" help:"ECN is requested by net.ipv4.tcp_ecn or the congestion control"`
+	ECNNegotiated uint8 `name:"ecn_negotiated" help:"ECN has been negotiated in the handshake"`
+	ECNSeen       uint8 `name:"ecn_seen" help:"ECN capable (ECT) packet has been received"`
+
+	MPTCPFallback        uint8  `name:"mptcp_fallback" help:"MPTCP connection fell back to TCP"`
+	MPTCPSubflows        uint8  `name:"mptcp_subflows" help:"MPTCP additional subflows"`
+	MPTCPSubflowsMax     uint8  `name:"mptcp_subflows_max" help:"MPTCP maximum additional subflows"`
+	MPTCPAddAddrSignal   uint8  `name:"mptcp_add_addr_signal" help:"MPTCP addresses announced to the peer"`
+	MPTCPAddAddrAccepted uint8  `name:"mptcp_add_addr_accepted" help:"MPTCP addresses accepted from the peer's announcements"`
+	MPTCPLocalAddrUsed   uint8  `name:"mptcp_local_addr_used" help:"MPTCP local addresses used by the subflows"`
+	MPTCPRetransmits     uint32 `name:"mptcp_retransmits" help:"MPTCP level retransmissions"`
+	MPTCPBytesSent       uint64 `name:"mptcp_bytes_sent" help:"MPTCP bytes sent over all the subflows"`
+	MPTCPBytesReceived   uint64 `name:"mptcp_bytes_received" help:"MPTCP bytes received over all the subflows"`
+	MPTCPBytesAcked      uint64 `name:"mptcp_bytes_acked" help:"MPTCP bytes acked at the connection level"`
+
+	TFOSynData         uint8 `name:"tfo_syn_data" help:"TCP Fast Open SYN data has been acknowledged"`
+	TFOConnectResponse int64 `name:"tfo_connect_response" help:"TCP connect to the first response byte, the unit is microsecond"`
+	TFOSaved           int64 `name:"tfo_saved" help:"TCP Fast Open saved time compared with the last connection without SYN data, the unit is microsecond"`
 
 	HTTPStatusCode int   `name:"http_status_code" help:"HTTP 1xx-5xx status code"`
 	HTTPRcvdBytes  int64 `name:"http_rcvd_bytes" help:"HTTP bytes received"`
 	HTTPRequest    int64 `name:"http_request" help:"HTTP request, the unit is microsecond"`
 	HTTPResponse   int64 `name:"http_response" help:"HTTP response, the unit is microsecond"`
+	HTTPRedirects  int64 `name:"http_redirects" help:"number of HTTP redirects followed"`
+	HTTPWrite      int64 `name:"http_write" help:"HTTP request write, the unit is microsecond"`
+	HTTPTTFB       int64 `name:"http_ttfb" help:"HTTP first response byte after the request is written, the unit is microsecond"`
+	HTTPHeaders    int64 `name:"http_headers" help:"HTTP response headers after the first byte, the unit is microsecond"`
+	HTTPThroughput int64 `name:"http_throughput" help:"HTTP response body transfer rate, the unit is bytes per second"`
+	HTTP2Settings  int64 `name:"http2_settings" help:"HTTP/2 server SETTINGS frame, the unit is microsecond"`
+	HTTP2Ping      int64 `name:"http2_ping" help:"HTTP/2 PING round trip, the unit is microsecond"`
+
+	HTTPProto string `name:"proto" info:"http" help:"HTTP protocol version"`
 
 	DNSResolve   int64 `name:"dns_resolve" help:"domain lookup, the unit is microsecond"`
 	TCPConnect   int64 `name:"tcp_connect" help:"TCP connect, the unit is microsecond"`
 	TLSHandshake int64 `name:"tls_handshake" help:"TLS handshake, the unit is microsecond"`
+	ConnLifetime int64 `name:"conn_lifetime" help:"persistent connection lifetime, the unit is microsecond"`
+
+	QUICHandshake   int64  `name:"quic_handshake" help:"QUIC handshake, the unit is microsecond"`
+	QUICRTT         int64  `name:"quic_rtt" help:"QUIC smoothed round trip time, the unit is microsecond"`
+	QUICMinRTT      int64  `name:"quic_min_rtt" help:"QUIC minimum round trip time, the unit is microsecond"`
+	QUICPacketsSent uint64 `name:"quic_packets_sent" help:"QUIC packets sent"`
+	QUICPacketsLost uint64 `name:"quic_packets_lost" help:"QUIC packets declared lost"`
+
+	PMTUEffective     uint32 `name:"pmtu_effective" help:"path MTU of the TCP_INFO after the PMTU probe"`
+	PMTUIPMtu         uint32 `name:"pmtu_ip_mtu" help:"path MTU of the route after the PMTU probe, IP_MTU"`
+	PMTUInterfaceMtu  uint32 `name:"pmtu_interface_mtu" help:"MTU of the outgoing interface, the PMTU probe sizes are capped at it"`
+	PMTUMaxSize       int64  `name:"pmtu_max_size" help:"largest packet size echoed as a single DF segment in the PMTU probe"`
+	PMTUBlackhole     uint8  `name:"pmtu_blackhole" help:"PMTU black hole detected, a packet size failed repeatedly while the smaller sizes succeeded"`
+	PMTUBlackholeSize int64  `name:"pmtu_blackhole_size" help:"packet size at which the PMTU black hole was detected"`
+
+	BulkBytes      int64  `name:"bulk_bytes" help:"bulk transfer bytes"`
+	BulkDuration   int64  `name:"bulk_duration" help:"bulk transfer, the unit is microsecond"`
+	BulkThroughput int64  `name:"bulk_throughput" help:"bulk transfer rate, the unit is bytes per second"`
+	BulkRttMin     uint32 `name:"bulk_rtt_min" help:"minimum sampled RTT during the bulk transfer"`
+	BulkRttMax     uint32 `name:"bulk_rtt_max" help:"maximum sampled RTT during the bulk transfer"`
+	BulkSndCwndMax uint32 `name:"bulk_snd_cwnd_max" help:"maximum sampled congestion window size during the bulk transfer"`
+
+	STARTTLSNegotiation int64 `name:"starttls_negotiation" help:"STARTTLS plaintext negotiation, the unit is microsecond"`
+
+	PayloadRTT      int64 `name:"payload_rtt" help:"payload first response byte, the unit is microsecond"`
+	PayloadComplete int64 `name:"payload_complete" help:"payload expected response, the unit is microsecond"`
+
+	ProtoCheck         int64  `name:"proto_check" help:"application protocol check, the unit is microsecond"`
+	ProtoCheckSuccess  uint8  `name:"proto_check_success" help:"application protocol check succeeded"`
+	ProtoServerVersion string `name:"server_version" info:"proto" help:"application protocol server version"`
+
+	GRPCHealthStatus int32 `name:"grpc_health_status" help:"gRPC health serving status, 1 is serving"`
+	GRPCHealthCheck  int64 `name:"grpc_health_check" help:"gRPC health check RPC, the unit is microsecond"`
+
+	WSUpgrade int64 `name:"ws_upgrade" help:"WebSocket upgrade handshake, the unit is microsecond"`
+	WSPingMin int64 `name:"ws_ping_min" help:"WebSocket minimum ping/pong round trip time, the unit is microsecond"`
+	WSPingAvg int64 `name:"ws_ping_avg" help:"WebSocket average ping/pong round trip time, the unit is microsecond"`
+	WSPingMax int64 `name:"ws_ping_max" help:"WebSocket maximum ping/pong round trip time, the unit is microsecond"`
+
+	TLSVersion     uint16 `name:"tls_version" help:"negotiated TLS version"`
+	TLSCipherSuite uint16 `name:"tls_cipher_suite" help:"negotiated TLS cipher suite"`
+	TLSResumed     uint8  `name:"tls_resumed" help:"TLS session has been resumed"`
+	TLSCertExpiry  int64  `name:"tls_cert_expiry_seconds" help:"TLS leaf certificate NotAfter, the unit is unix time in seconds"`
+
+	TLSVersionName     string `name:"version" info:"tls" help:"negotiated TLS version name"`
+	TLSCipherSuiteName string `name:"cipher_suite" info:"tls" help:"negotiated TLS cipher suite name"`
+	TLSProto           string `name:"alpn" info:"tls" help:"negotiated TLS application protocol (ALPN)"`
+	TLSCertIssuer      string `name:"cert_issuer" info:"tls" help:"TLS leaf certificate issuer"`
+	TLSCertSANs        string `name:"cert_sans" info:"tls" help:"TLS leaf certificate subject alternative names"`
 
 	TCPConnectError int64 `name:"tcp_connect_error" help:"total TCP connect error" kind:"counter"`
 	DNSResolveError int64 `name:"dns_resolve_error" help:"total DNS resolve error" kind:"counter"`
+
+	TLSHandshakeError  int64 `name:"tls_handshake_error" help:"total TLS handshake error" kind:"counter"`
+	QUICHandshakeError int64 `name:"quic_handshake_error" help:"total QUIC handshake error" kind:"counter"`
+
+	HTTPStatusMismatch  int64 `name:"http_status_mismatch" help:"total HTTP unexpected status code" kind:"counter"`
+	HTTPBodyCheckFailed int64 `name:"http_body_check_failed" help:"total HTTP response body check failure" kind:"counter"`
+
+	PayloadMatchFailed int64 `name:"payload_match_failed" help:"total payload expected response failure" kind:"counter"`
+	GRPCHealthError    int64 `name:"grpc_health_error" help:"total gRPC health check error" kind:"counter"`
+	WSError            int64 `name:"ws_error" help:"total WebSocket upgrade and ping error" kind:"counter"`
+	ResponderError     int64 `name:"responder_error" help:"total tcpprobe responder TCP_INFO exchange error" kind:"counter"`
+	ConnDropped        int64 `name:"conn_dropped" help:"total persistent connection dropped" kind:"counter"`
+
+	// server represents the responder's TCP_INFO of the connection
+	server *stats `unexported:"true"`
+}
+
+// defaultPorts represents the well-known ports of the schemes
+var defaultPorts = map[string]string{
+	"http":     "80",
+	"https":    "443",
+	"ws":       "80",
+	"wss":      "443",
+	"smtp":     "25",
+	"imap":     "143",
+	"pop3":     "110",
+	"ftp":      "21",
+	"ldaps":    "636",
+	"imaps":    "993",
+	"pop3s":    "995",
+	"smtps":    "465",
+	"redis":    "6379",
+	"mysql":    "3306",
+	"ssh":      "22",
+	"postgres": "5432",
 }
 
 // client represents a proble client to specific target
@@ -99,8 +211,22 @@ type client struct {
 	timestamp int64
 	urlSchema *url.URL
 
-	conn net.Conn
-	req  *request
+	conn    net.Conn
+	req     *request
+	dials   int
+	closers []io.Closer
+	hops    []hop
+	samples []sample
+
+	pmtuSteps []pmtuStep
+
+	tfoBaseline int64
+
+	tlsConn    *tls.Conn
+	httpClient *http.Client
+
+	// the TLS sessions are resumed across the probes of the target
+	tlsSessionCache tls.ClientSessionCache
 
 	subCh []chan *stats
 	mu    *sync.Mutex
@@ -115,9 +241,10 @@ func newClient(req *request, target string) *client {
 	}
 
 	c := &client{
-		target:    target,
-		urlSchema: urlSchema,
-		req:       req,
+		target:          target,
+		urlSchema:       urlSchema,
+		req:             req,
+		tlsSessionCache: tls.NewLRUClientSessionCache(0),
 	}
 
 	if req.grpc {
@@ -139,63 +266,180 @@ func (c *client) connect(ctx context.Context) error {
 
 	c.addr = addr
 
+	if c.req.ecn {
+		if err := c.checkECN(); err != nil {
+			return err
+		}
+	}
+
+	// HTTP/3 runs over QUIC, the connection is made by the exchange
+	if c.isHTTP3() {
+		c.conn, err = c.listenUDP()
+		return err
+	}
+
+	c.conn, err = c.dial(ctx, addr)
+
+	return err
+}
+
+// dial connects to the given address through the
+// instrumented socket and measures the TCP connect
+func (c *client) dial(ctx context.Context, addr string) (net.Conn, error) {
 	d := net.Dialer{
-		LocalAddr: getSrcAddr(c.req.srcAddr),
-		Control:   c.control,
+		Control: c.control,
+	}
+
+	if c.req.isKeepAlive() {
+		d.KeepAlive = -1
 	}
+
+	// the socket is created with IPPROTO_MPTCP, it's plain TCP if the kernel
+	// doesn't support it and the connection falls back if the server doesn't
+	d.SetMultipathTCP(c.req.mptcp)
 	ctx, cancel := context.WithTimeout(ctx, c.req.timeout)
 	defer cancel()
 
-	t := time.Now()
-	c.conn, err = d.DialContext(ctx, "tcp", addr)
+	var (
+		conn net.Conn
+		t    time.Time
+	)
+
+	// the source interface's address is looked up in the namespace
+	err := inNetns(c.req.netns, func() error {
+		var err error
+		d.LocalAddr, err = getSrcAddr(c.req.srcAddr, c.isIPv4())
+		if err != nil {
+			return err
+		}
+
+		t = time.Now()
+		conn, err = d.DialContext(ctx, "tcp", addr)
+		return err
+	})
 	if err != nil {
 		c.stats.TCPConnectError++
-		return err
+		return nil, err
 	}
 
 	c.stats.TCPConnect = time.Since(t).Microseconds()
 
-	return nil
+	return conn, nil
 }
 
 func (c *client) dialContext(ctx context.Context, network, addr string) (net.Conn, error) {
-	return c.conn, nil
+	c.dials++
+
+	// the first connection has been already established
+	if c.dials == 1 {
+		return c.conn, nil
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	c.stats.DNSResolve = 0
+	c.stats.TLSHandshake = 0
+	ipAddr, err := c.resolve(host, port)
+	if err != nil {
+		return nil, err
+	}
+
+	c.addr = ipAddr
+	conn, err := c.dial(ctx, ipAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	c.closers = append(c.closers, c.conn)
+	c.conn = conn
+
+	return conn, nil
 }
 
 func (c *client) dialTLSContext(ctx context.Context, network, addr string) (net.Conn, error) {
-	config := tls.Config{InsecureSkipVerify: c.req.insecure, ServerName: c.serverName()}
-	tlsConn := tls.Client(c.conn, &config)
+	conn, err := c.dialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
 
-	t := time.Now()
-	err := tlsConn.Handshake()
-	c.stats.TLSHandshake = time.Since(t).Microseconds()
+	config := c.httpTLSConfig(c.serverName())
+	if c.dials > 1 && c.req.serverName == "" {
+		config.ServerName, _, _ = net.SplitHostPort(addr)
+	}
 
-	return tlsConn, err
+	return c.tlsHandshake(conn, config)
 }
 
 func (c *client) control(network string, address string, conn syscall.RawConn) error {
+	if err := c.controlSocket(network, address, conn); err != nil {
+		return err
+	}
+
 	return conn.Control(func(fd uintptr) {
+		setSocketOptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_NODELAY, boolToInt(!c.req.soTCPNoDelay), true)
+		setSocketOptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_QUICKACK, boolToInt(!c.req.soTCPQuickACK), true)
+		setSocketOptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_MAXSEG, c.req.soMaxSegSize, false)
+		setSocketOptInt(int(fd), syscall.IPPROTO_TCP, tcpFastOpenConnect, boolToInt(c.req.soTCPFastOpen), false)
+		c.setKeepAlive(int(fd))
+
+		err := syscall.SetsockoptString(int(fd), syscall.IPPROTO_TCP, syscall.TCP_CONGESTION, c.req.soCongestion)
+		if c.req.soCongestion != "" && err != nil {
+			log.Fatal(os.NewSyscallError("congestion-avoidance algorithm error", err))
+		}
+	})
+}
+
+// controlSocket sets the socket and IP level options, they apply
+// to both the TCP and the HTTP/3 UDP sockets. the connection fails
+// if the fwmark or the interface can't be set, otherwise it'd take
+// the default route while its metrics are labeled with them.
+func (c *client) controlSocket(network string, address string, conn syscall.RawConn) error {
+	var sErr error
+
+	err := conn.Control(func(fd uintptr) {
+		if c.req.soMark != 0 {
+			if err := syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_MARK, c.req.soMark); err != nil {
+				sErr = os.NewSyscallError("setsockopt fwmark", err)
+				return
+			}
+		}
+
+		// binding to a VRF device makes the routing lookup in the VRF's table
+		if c.req.soInterface != "" {
+			if err := syscall.BindToDevice(int(fd), c.req.soInterface); err != nil {
+				sErr = os.NewSyscallError("bind to device", err)
+				return
+			}
+		}
 
 		setSocketOptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_PRIORITY, c.req.soPriority, false)
 		setSocketOptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_SNDBUF, c.req.soSndBuf, false)
 		setSocketOptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUF, c.req.soRcvBuf, false)
-		setSocketOptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_NODELAY, boolToInt(!c.req.soTCPNoDelay), true)
-		setSocketOptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_QUICKACK, boolToInt(!c.req.soTCPQuickACK), true)
-		setSocketOptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_MAXSEG, c.req.soMaxSegSize, false)
+
+		// the PMTU probe sets DF and follows the ICMP fragmentation needed
+		pmtuMode := 0
+		if c.req.pmtu {
+			pmtuMode = syscall.IP_PMTUDISC_DO
+		}
 
 		if c.isIPv4() {
 			setSocketOptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TOS, c.req.soIPTOS, false)
 			setSocketOptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TTL, c.req.soIPTTL, false)
+			setSocketOptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER, pmtuMode, false)
 		} else {
 			setSocketOptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_UNICAST_HOPS, c.req.soIPTTL, false)
 			setSocketOptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS, c.req.soIPTOS, false)
-		}
-
-		err := syscall.SetsockoptString(int(fd), syscall.IPPROTO_TCP, syscall.TCP_CONGESTION, c.req.soCongestion)
-		if c.req.soCongestion != "" && err != nil {
-			log.Fatal(os.NewSyscallError("congestion-avoidance algorithm error", err))
+			setSocketOptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_MTU_DISCOVER, pmtuMode, false)
 		}
 	})
+	if err != nil {
+		return err
+	}
+
+	return sErr
 }
 
 func setSocketOptInt(fd int, level int, opt int, value int, zeroExc bool) {
@@ -226,8 +470,8 @@ func (c *client) getHostPort() (string, string, error) {
 			host = c.target
 		}
 
-		if c.urlSchema.Scheme == "https" {
-			port = "443"
+		if p, ok := defaultPorts[c.scheme()]; ok {
+			port = p
 		} else {
 			port = "80"
 		}
@@ -244,6 +488,10 @@ func (c *client) getAddr() (string, error) {
 		return "", err
 	}
 
+	return c.resolve(host, port)
+}
+
+func (c *client) resolve(host, port string) (string, error) {
 	if ok := isIPAddr(host); ok {
 		return net.JoinHostPort(host, port), nil
 	}
@@ -278,6 +526,13 @@ func (c *client) getAddr() (string, error) {
 }
 
 func (c *client) close() {
+	for _, closer := range c.closers {
+		closer.Close()
+	}
+	c.closers = c.closers[:0]
+	c.tlsConn = nil
+	c.httpClient = nil
+
 	c.conn.Close()
 }
 
@@ -286,44 +541,6 @@ func (c *client) isIPv4() bool {
 	return net.ParseIP(ip).To4() != nil
 }
 
-func (c *client) httpGet() error {
-	tr := &http.Transport{
-		DialContext:       c.dialContext,
-		DialTLSContext:    c.dialTLSContext,
-		ForceAttemptHTTP2: c.req.http2,
-	}
-
-	httpClient := &http.Client{
-		Timeout:       c.req.timeoutHTTP,
-		Transport:     tr,
-		CheckRedirect: c.noRedirect,
-	}
-	t := time.Now()
-	resp, err := httpClient.Get(c.target)
-	if err != nil {
-		return err
-	}
-	c.stats.HTTPRequest = time.Since(t).Microseconds()
-
-	t = time.Now()
-	written, err := io.Copy(ioutil.Discard, resp.Body)
-	if err != nil {
-		return err
-	}
-	c.stats.HTTPResponse = time.Since(t).Microseconds()
-
-	c.stats.HTTPStatusCode = resp.StatusCode
-	c.stats.HTTPRcvdBytes = written
-
-	resp.Body.Close()
-
-	return nil
-}
-
-func (c *client) noRedirect(req *http.Request, via []*http.Request) error {
-	return fmt.Errorf("%s has been redirected", c.target)
-}
-
 func (c *client) serverName() string {
 	var hostPort string
 
@@ -346,6 +563,21 @@ func (c *client) serverName() string {
 }
 
 func (c *client) probe(ctx context.Context) {
+	if err := c.checkTFO(); err != nil {
+		log.Println(err)
+		return
+	}
+
+	if err := c.checkPersistent(); err != nil {
+		log.Println(err)
+		return
+	}
+
+	if c.req.persistent {
+		c.persistent(ctx)
+		return
+	}
+
 	counter := -1
 	wait := c.getInterval(ctx)
 	for counter < c.req.count-1 || c.req.count == 0 {
@@ -367,8 +599,12 @@ func (c *client) probe(ctx context.Context) {
 			continue
 		}
 
-		if strings.HasPrefix(c.target, "http") {
-			if err := c.httpGet(); err != nil {
+		if err = c.exchange(); err != nil {
+			log.Println(err)
+		}
+
+		if c.req.responder {
+			if err = c.getServerTCPInfo(); err != nil {
 				log.Println(err)
 			}
 		}
@@ -387,6 +623,43 @@ func (c *client) probe(ctx context.Context) {
 	}
 }
 
+// exchange performs the target's application layer
+// exchange over the established connection
+func (c *client) exchange() error {
+	switch {
+	case c.isBulk():
+		return c.bulk()
+	case c.req.pmtu:
+		return c.pmtuProbe()
+	case c.scheme() == "http" || c.scheme() == "https":
+		return c.httpGet()
+	case c.starttlsProto() != "":
+		return c.startTLS()
+	case c.isTLS():
+		return c.tlsProbe()
+	case c.isWebSocket():
+		return c.webSocket()
+	case c.isGRPC():
+		return c.grpcHealthCheck()
+	case c.getChecker() != nil:
+		return c.protoCheck(c.getChecker())
+	case c.hasPayload():
+		return c.payloadExchange(c.conn)
+	}
+
+	return nil
+}
+
+// scheme returns the target's URL scheme, a target
+// without :// like host:port doesn't have any scheme
+func (c *client) scheme() string {
+	if !strings.Contains(c.target, "://") {
+		return ""
+	}
+
+	return strings.ToLower(c.urlSchema.Scheme)
+}
+
 func (c *client) publish() {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -415,42 +688,97 @@ func (c *client) unsubscribe(ch chan *stats) {
 	}
 }
 
-func getSrcAddr(src string) net.Addr {
+// getSrcAddr returns the source address, the source can be an IP
+// address or an interface name which its address is picked by
+// the given address family
+func getSrcAddr(src string, ipv4 bool) (net.Addr, error) {
 	if src == "" {
-		return nil
+		return nil, nil
 	}
 
-	ip := net.ParseIP(src)
+	if ip := net.ParseIP(src); ip != nil {
+		return &net.TCPAddr{IP: ip, Port: 0, Zone: ""}, nil
+	}
 
-	return &net.TCPAddr{IP: ip, Port: 0, Zone: ""}
+	iface, err := net.InterfaceByName(src)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+
+	var linkLocal net.IP
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || (ipNet.IP.To4() != nil) != ipv4 {
+			continue
+		}
+
+		// the IPv6 link-local address needs the zone, it's the last resort
+		if ipNet.IP.IsLinkLocalUnicast() {
+			if linkLocal == nil {
+				linkLocal = ipNet.IP
+			}
+			continue
+		}
+
+		return &net.TCPAddr{IP: ipNet.IP, Port: 0, Zone: ""}, nil
+	}
+
+	if linkLocal != nil {
+		return &net.TCPAddr{IP: linkLocal, Port: 0, Zone: iface.Name}, nil
+	}
+
+	family := "IPv6"
+	if ipv4 {
+		family = "IPv4"
+	}
+
+	return nil, fmt.Errorf("interface %s has no %s address", src, family)
 }
 
 func (c *client) getTCPInfo() error {
-	tcpConn := c.conn.(*net.TCPConn)
-	if tcpConn == nil {
-		return errors.New("tcp conn is nil")
+	// TCP_INFO isn't available for the QUIC connections
+	if _, ok := c.conn.(*net.UDPConn); ok {
+		return nil
 	}
 
-	file, err := tcpConn.File()
-	if err != nil {
+	if err := readTCPInfo(c.conn, &c.stats); err != nil {
 		return err
 	}
-	defer file.Close()
 
-	fd := file.Fd()
-	size := uint32(232)
+	c.stats.TCPOptions = tcpOptions(c.stats.Options)
+	c.setECNStats()
 
-	_, _, e := syscall.Syscall6(syscall.SYS_GETSOCKOPT, fd, syscall.SOL_TCP, syscall.TCP_INFO,
-		uintptr(unsafe.Pointer(&c.stats)), uintptr(unsafe.Pointer(&size)), 0)
-	if e != 0 {
-		return fmt.Errorf("syscall err number=%d", e)
+	if c.req.soTCPFastOpen {
+		c.setTFOStats()
+	}
+
+	if c.req.mptcp {
+		if err := c.getMPTCPInfo(); err != nil {
+			return err
+		}
+	}
+
+	rawConn, err := c.conn.(*net.TCPConn).SyscallConn()
+	if err != nil {
+		return err
 	}
 
 	ca := make([]byte, 10)
-	size = uint32(len(ca))
+	size := uint32(len(ca))
 
-	_, _, e = syscall.Syscall6(syscall.SYS_GETSOCKOPT, fd, syscall.IPPROTO_TCP, syscall.TCP_CONGESTION,
-		uintptr(unsafe.Pointer(&ca[0])), uintptr(unsafe.Pointer(&size)), 0)
+	var e syscall.Errno
+	err = rawConn.Control(func(fd uintptr) {
+		_, _, e = syscall.Syscall6(syscall.SYS_GETSOCKOPT, fd, syscall.IPPROTO_TCP, syscall.TCP_CONGESTION,
+			uintptr(unsafe.Pointer(&ca[0])), uintptr(unsafe.Pointer(&size)), 0)
+	})
+	if err != nil {
+		return err
+	}
 	if e != 0 {
 		return fmt.Errorf("syscall err number=%d", e)
 	}
@@ -460,6 +788,36 @@ func (c *client) getTCPInfo() error {
 	return nil
 }
 
+// readTCPInfo reads the connection's TCP_INFO into the given stats,
+// it's safe to call while the connection is in use
+func readTCPInfo(conn net.Conn, s *stats) error {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok || tcpConn == nil {
+		return errors.New("tcp conn is nil")
+	}
+
+	rawConn, err := tcpConn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	size := uint32(232)
+
+	var e syscall.Errno
+	err = rawConn.Control(func(fd uintptr) {
+		_, _, e = syscall.Syscall6(syscall.SYS_GETSOCKOPT, fd, syscall.SOL_TCP, syscall.TCP_INFO,
+			uintptr(unsafe.Pointer(s)), uintptr(unsafe.Pointer(&size)), 0)
+	})
+	if err != nil {
+		return err
+	}
+	if e != 0 {
+		return fmt.Errorf("syscall err number=%d", e)
+	}
+
+	return nil
+}
+
 func (c *client) getInterval(ctx context.Context) time.Duration {
 	if v := ctx.Value(intervalKey); v != nil {
 		d, err := time.ParseDuration(v.(string))
@@ -2,6 +2,7 @@ package main
 
 import (
 	"io/ioutil"
+	"strings"
 
 	yml "gopkg.in/yaml.v3"
 )
@@ -13,9 +14,32 @@ type config struct {
 
 // target represents a target/host
 type target struct {
-	Addr     string
-	Interval string
-	Labels   map[string]string
+	Addr      string
+	Interval  string
+	Labels    map[string]string
+	Cert      string
+	Key       string
+	CACert    string `yaml:"cacert"`
+	StartTLS  string `yaml:"starttls"`
+	Netns     string
+	Interface string
+	Fwmark    int
+	HTTP      *httpConfig
+	Payload   *payloadConfig
+}
+
+// httpConfig represents a target's HTTP request
+type httpConfig struct {
+	Method       string
+	Headers      map[string]string
+	Body         string
+	BodyFile     string `yaml:"body_file"`
+	ExpectStatus string `yaml:"expect_status"`
+	BodyContains string `yaml:"body_contains"`
+	BodyRegex    string `yaml:"body_regex"`
+	BodyJSON     string `yaml:"body_json"`
+
+	FollowRedirects int `yaml:"follow_redirects"`
 }
 
 func getConfig(filename string) (*config, error) {
@@ -36,3 +60,48 @@ func getConfig(filename string) (*config, error) {
 
 	return c, nil
 }
+
+// request returns a copy of the given request which
+// is overridden by the target's parameters
+func (t target) request(req *request) (*request, error) {
+	r := *req
+
+	if err := r.setTLS(t.Cert, t.Key, t.CACert); err != nil {
+		return nil, err
+	}
+
+	if t.Netns != "" {
+		r.netns = t.Netns
+	}
+
+	if t.Interface != "" {
+		r.soInterface = t.Interface
+	}
+
+	if t.Fwmark != 0 {
+		r.soMark = t.Fwmark
+	}
+
+	if t.StartTLS != "" {
+		r.starttls = strings.ToLower(t.StartTLS)
+	}
+
+	if t.Payload != nil {
+		if err := r.setPayload(t.Payload); err != nil {
+			return nil, err
+		}
+	}
+
+	if t.HTTP != nil {
+		headers := []string{}
+		for k, v := range t.HTTP.Headers {
+			headers = append(headers, k+":"+v)
+		}
+
+		if err := r.setHTTP(t.HTTP, headers); err != nil {
+			return nil, err
+		}
+	}
+
+	return &r, nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+const tcpECNSysctl = "/proc/sys/net/ipv4/tcp_ecn"
+
+// ecnCongestion represents the congestion controls which
+// request ECN on the socket regardless of net.ipv4.tcp_ecn
+var ecnCongestion = map[string]bool{"dctcp": true}
+
+// checkECN returns an error if ECN isn't requested on the outgoing
+// connections, Linux has no per socket option for that, only
+// net.ipv4.tcp_ecn=1 or 3 in the probe's network namespace
+// or a congestion control which needs ECN requests it
+func (c *client) checkECN() error {
+	c.stats.ECNRequested = 0
+
+	if ecnCongestion[c.req.soCongestion] {
+		c.stats.ECNRequested = 1
+		return nil
+	}
+
+	var b []byte
+	err := inNetns(c.req.netns, func() error {
+		var err error
+		b, err = ioutil.ReadFile(tcpECNSysctl)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("%s ECN: %v", c.target, err)
+	}
+
+	switch mode := strings.TrimSpace(string(b)); mode {
+	case "1", "3":
+		c.stats.ECNRequested = 1
+	default:
+		return fmt.Errorf("%s ECN is not requested as net.ipv4.tcp_ecn is %s, set it to 1 or use an ECN congestion control e.g. dctcp", c.target, mode)
+	}
+
+	return nil
+}
+
+func (c *client) setECNStats() {
+	c.stats.ECNNegotiated = uint8(boolToInt(c.stats.Options&tcpOptECN != 0))
+	c.stats.ECNSeen = uint8(boolToInt(c.stats.Options&tcpOptECNSeen != 0))
+}
@@ -171,5 +171,18 @@ func stats2pbStruct(stats *stats) *pbstruct.Struct {
 
 	}
 
+	if stats.server != nil {
+		server := &pbstruct.Struct{Fields: make(map[string]*pbstruct.Value)}
+		fields := tcpInfoFields(stats.server)
+		for k, v := range stats2pbStruct(stats.server).Fields {
+			if _, ok := fields[k]; ok {
+				server.Fields[k] = v
+			}
+		}
+		r.Fields["Server"] = &pbstruct.Value{
+			Kind: &pbstruct.Value_StructValue{StructValue: server},
+		}
+	}
+
 	return r
 }
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// isGRPC returns true if the target is a gRPC health check, e.g.
+// grpc://host:port/service or grpcs://host:port/service over TLS
+func (c *client) isGRPC() bool {
+	return c.scheme() == "grpc" || c.scheme() == "grpcs"
+}
+
+// grpcHealthCheck calls the grpc.health.v1.Health/Check over the
+// established connection, the service is taken from the target's path
+func (c *client) grpcHealthCheck() error {
+	var conn net.Conn = c.conn
+
+	c.stats.GRPCHealthStatus = 0
+	c.stats.GRPCHealthCheck = 0
+
+	if c.scheme() == "grpcs" {
+		config := c.tlsConfig(c.serverName())
+		config.NextProtos = []string{"h2"}
+
+		tlsConn, err := c.tlsHandshake(c.conn, config)
+		if err != nil {
+			return err
+		}
+		conn = tlsConn
+	}
+
+	// the connection is given only once to not let
+	// the gRPC client to reconnect through a new socket
+	dialed := false
+	dialer := func(ctx context.Context, addr string) (net.Conn, error) {
+		if dialed {
+			return nil, errors.New("connection has been already used")
+		}
+		dialed = true
+		return conn, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.req.timeout)
+	defer cancel()
+
+	cc, err := grpc.DialContext(ctx, c.addr,
+		grpc.WithContextDialer(dialer),
+		grpc.WithInsecure(),
+		grpc.WithBlock(),
+		grpc.WithAuthority(c.urlSchema.Host),
+	)
+	if err != nil {
+		c.stats.GRPCHealthError++
+		return fmt.Errorf("%s grpc: %v", c.target, err)
+	}
+
+	c.closers = append(c.closers, cc)
+
+	service := strings.TrimPrefix(c.urlSchema.Path, "/")
+
+	t := time.Now()
+	resp, err := healthpb.NewHealthClient(cc).Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+	if err != nil {
+		c.stats.GRPCHealthError++
+		return fmt.Errorf("%s grpc health check: %v", c.target, err)
+	}
+	c.stats.GRPCHealthCheck = time.Since(t).Microseconds()
+
+	c.stats.GRPCHealthStatus = int32(resp.GetStatus())
+	if resp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		return fmt.Errorf("%s grpc health status: %s", c.target, resp.GetStatus())
+	}
+
+	return nil
+}
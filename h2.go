@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// h2RoundTripper sends the requests over the HTTP/2 client connections
+// which are made through the client's instrumented dialers, it supports
+// HTTP/2 over TLS and cleartext HTTP/2 with prior knowledge (h2c). the
+// requests fall back to HTTP/1.1 if a TLS server doesn't negotiate h2
+type h2RoundTripper struct {
+	client   *client
+	tr       *http2.Transport
+	h1       *http.Transport
+	conns    map[string]*http2.ClientConn
+	fallback map[string]bool
+	pending  net.Conn
+	last     *http2.ClientConn
+}
+
+// settingsConn records the time to the first received bytes which
+// is the server's SETTINGS frame based on the HTTP/2 connection preface
+type settingsConn struct {
+	net.Conn
+	start    time.Time
+	duration int64
+}
+
+func (c *client) newH2RoundTripper() *h2RoundTripper {
+	h := &h2RoundTripper{
+		client:   c,
+		tr:       &http2.Transport{AllowHTTP: true},
+		conns:    map[string]*http2.ClientConn{},
+		fallback: map[string]bool{},
+	}
+
+	h.h1 = &http.Transport{
+		DialContext:    c.dialContext,
+		DialTLSContext: h.dialTLS,
+	}
+
+	return h
+}
+
+func (h *h2RoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	addr := canonicalAddr(req)
+
+	if h.fallback[addr] {
+		return h.h1.RoundTrip(req)
+	}
+
+	var sc *settingsConn
+
+	cc, reused := h.conns[addr]
+	if !reused || !cc.CanTakeNewRequest() {
+		var err error
+		if cc, sc, err = h.dial(req.Context(), req.URL.Scheme, addr); err != nil {
+			return nil, err
+		}
+		reused = false
+	}
+
+	// the server has negotiated HTTP/1.1 over the new connection
+	if cc == nil {
+		return h.h1.RoundTrip(req)
+	}
+
+	if trace := httptrace.ContextClientTrace(req.Context()); trace != nil && trace.GotConn != nil {
+		trace.GotConn(httptrace.GotConnInfo{Reused: reused})
+	}
+
+	h.last = cc
+
+	resp, err := cc.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// the SETTINGS frame has been received by the time of the response
+	if sc != nil {
+		h.client.stats.HTTP2Settings = sc.settings()
+	}
+
+	return resp, nil
+}
+
+func (h *h2RoundTripper) dial(ctx context.Context, scheme, addr string) (*http2.ClientConn, *settingsConn, error) {
+	var (
+		conn net.Conn
+		err  error
+	)
+
+	if scheme == "https" {
+		conn, err = h.client.dialTLSContext(ctx, "tcp", addr)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if conn.(*tls.Conn).ConnectionState().NegotiatedProtocol != "h2" {
+			h.fallback[addr] = true
+			h.pending = conn
+			return nil, nil, nil
+		}
+	} else {
+		conn, err = h.client.dialContext(ctx, "tcp", addr)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	sc := &settingsConn{Conn: conn, start: time.Now()}
+	cc, err := h.tr.NewClientConn(sc)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	h.client.closers = append(h.client.closers, cc)
+	h.conns[addr] = cc
+
+	return cc, sc, nil
+}
+
+// dialTLS hands the connection which has negotiated HTTP/1.1
+// over to the HTTP/1.1 transport, then it dials as usual
+func (h *h2RoundTripper) dialTLS(ctx context.Context, network, addr string) (net.Conn, error) {
+	if conn := h.pending; conn != nil {
+		h.pending = nil
+		return conn, nil
+	}
+
+	return h.client.dialTLSContext(ctx, network, addr)
+}
+
+// ping measures the HTTP/2 PING round trip time over the last connection
+func (h *h2RoundTripper) ping(timeout time.Duration) error {
+	if h.last == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	t := time.Now()
+	if err := h.last.Ping(ctx); err != nil {
+		return err
+	}
+	h.client.stats.HTTP2Ping = time.Since(t).Microseconds()
+
+	return nil
+}
+
+func (s *settingsConn) Read(b []byte) (int, error) {
+	n, err := s.Conn.Read(b)
+	if n > 0 && atomic.LoadInt64(&s.duration) == 0 {
+		atomic.StoreInt64(&s.duration, time.Since(s.start).Microseconds())
+	}
+
+	return n, err
+}
+
+func (s *settingsConn) settings() int64 {
+	return atomic.LoadInt64(&s.duration)
+}
+
+func canonicalAddr(req *http.Request) string {
+	port := req.URL.Port()
+	if port == "" {
+		port = defaultPorts[req.URL.Scheme]
+	}
+
+	return net.JoinHostPort(req.URL.Hostname(), port)
+}
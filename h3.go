@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync/atomic"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/quic-go/quic-go/logging"
+)
+
+// h3RoundTripper sends the requests over HTTP/3, the QUIC
+// connections are multiplexed on the client's UDP socket
+type h3RoundTripper struct {
+	client *client
+	tr     *quic.Transport
+	h3     *http3.Transport
+	tracer *quicTracer
+	conns  map[string]*http3.ClientConn
+}
+
+// quicTracer collects the QUIC connection metrics
+// which are updated by the QUIC library goroutines
+type quicTracer struct {
+	rtt    int64
+	minRTT int64
+	sent   uint64
+	lost   uint64
+}
+
+func (c *client) isHTTP3() bool {
+	return c.req.http3 && c.scheme() == "https"
+}
+
+// listenUDP opens the UDP socket for the QUIC connections
+// with the socket and IP level options of the TCP connections
+func (c *client) listenUDP() (net.Conn, error) {
+	var conn net.PacketConn
+
+	lc := net.ListenConfig{Control: c.controlSocket}
+	err := inNetns(c.req.netns, func() error {
+		srcAddr, err := getSrcAddr(c.req.srcAddr, c.isIPv4())
+		if err != nil {
+			return err
+		}
+
+		laddr := &net.UDPAddr{}
+		if a, ok := srcAddr.(*net.TCPAddr); ok {
+			laddr.IP, laddr.Zone = a.IP, a.Zone
+		}
+
+		conn, err = lc.ListenPacket(context.Background(), "udp", laddr.String())
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return conn.(*net.UDPConn), nil
+}
+
+func (c *client) newH3RoundTripper() *h3RoundTripper {
+	tr := &quic.Transport{Conn: c.conn.(*net.UDPConn)}
+	c.closers = append(c.closers, tr)
+
+	return &h3RoundTripper{
+		client: c,
+		tr:     tr,
+		h3:     &http3.Transport{},
+		tracer: &quicTracer{},
+		conns:  map[string]*http3.ClientConn{},
+	}
+}
+
+func (h *h3RoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	addr := canonicalAddr(req)
+
+	cc, reused := h.conns[addr]
+	if !reused {
+		var err error
+		if cc, err = h.dial(req.Context(), addr); err != nil {
+			return nil, err
+		}
+	}
+
+	if trace := httptrace.ContextClientTrace(req.Context()); trace != nil && trace.GotConn != nil {
+		trace.GotConn(httptrace.GotConnInfo{Reused: reused})
+	}
+
+	return cc.RoundTrip(req)
+}
+
+func (h *h3RoundTripper) dial(ctx context.Context, addr string) (*http3.ClientConn, error) {
+	c := h.client
+	c.dials++
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	// the first address has been already resolved
+	if c.dials > 1 {
+		c.stats.DNSResolve = 0
+		c.stats.QUICHandshake = 0
+		if c.addr, err = c.resolve(host, port); err != nil {
+			return nil, err
+		}
+	}
+
+	udpAddr, err := net.ResolveUDPAddr("udp", c.addr)
+	if err != nil {
+		return nil, err
+	}
+
+	config := c.tlsConfig(c.serverName())
+	if c.dials > 1 && c.req.serverName == "" {
+		config.ServerName = host
+	}
+	config.NextProtos = []string{http3.NextProtoH3}
+
+	ctx, cancel := context.WithTimeout(ctx, c.req.timeout)
+	defer cancel()
+
+	t := time.Now()
+	conn, err := h.tr.Dial(ctx, udpAddr, config, &quic.Config{Tracer: h.tracer.connectionTracer})
+	if err != nil {
+		c.stats.QUICHandshakeError++
+		return nil, err
+	}
+	c.stats.QUICHandshake = time.Since(t).Microseconds()
+
+	c.setTLSStats(conn.ConnectionState().TLS)
+
+	cc := h.h3.NewClientConn(conn)
+	h.conns[addr] = cc
+
+	return cc, nil
+}
+
+// setStats copies the QUIC metrics to the client's stats
+func (h *h3RoundTripper) setStats() {
+	h.client.stats.QUICRTT = atomic.LoadInt64(&h.tracer.rtt)
+	h.client.stats.QUICMinRTT = atomic.LoadInt64(&h.tracer.minRTT)
+	h.client.stats.QUICPacketsSent = atomic.LoadUint64(&h.tracer.sent)
+	h.client.stats.QUICPacketsLost = atomic.LoadUint64(&h.tracer.lost)
+}
+
+func (q *quicTracer) connectionTracer(ctx context.Context, p logging.Perspective, id quic.ConnectionID) *logging.ConnectionTracer {
+	return &logging.ConnectionTracer{
+		UpdatedMetrics: func(rttStats *logging.RTTStats, cwnd, bytesInFlight logging.ByteCount, packetsInFlight int) {
+			atomic.StoreInt64(&q.rtt, rttStats.SmoothedRTT().Microseconds())
+			atomic.StoreInt64(&q.minRTT, rttStats.MinRTT().Microseconds())
+		},
+		SentLongHeaderPacket: func(*logging.ExtendedHeader, logging.ByteCount, logging.ECN, *logging.AckFrame, []logging.Frame) {
+			atomic.AddUint64(&q.sent, 1)
+		},
+		SentShortHeaderPacket: func(*logging.ShortHeader, logging.ByteCount, logging.ECN, *logging.AckFrame, []logging.Frame) {
+			atomic.AddUint64(&q.sent, 1)
+		},
+		LostPacket: func(logging.EncryptionLevel, logging.PacketNumber, logging.PacketLossReason) {
+			atomic.AddUint64(&q.lost, 1)
+		},
+	}
+}
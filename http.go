@@ -0,0 +1,477 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// statusRange represents an inclusive range of HTTP status codes
+type statusRange struct {
+	min int
+	max int
+}
+
+// statusRanges represents the expected HTTP status codes
+type statusRanges []statusRange
+
+// jsonCheck represents an expected value at a JSON path
+type jsonCheck struct {
+	path  []string
+	value string
+}
+
+// httpTimings represents the round trip events, the trace
+// hooks can be called from the transport's goroutines
+type httpTimings struct {
+	sync.Mutex
+	gotConn      time.Time
+	wroteRequest time.Time
+	firstByte    time.Time
+}
+
+// hop represents a HTTP request/response on the way to the final
+// response, the durations are only measured for new connections
+type hop struct {
+	URL          string
+	StatusCode   int
+	DNSResolve   int64
+	TCPConnect   int64
+	TLSHandshake int64
+	TTFB         int64
+}
+
+func (c *client) httpGet() error {
+	var (
+		tr http.RoundTripper = &http.Transport{
+			DialContext:    c.dialContext,
+			DialTLSContext: c.dialTLSContext,
+		}
+		h2 *h2RoundTripper
+		h3 *h3RoundTripper
+	)
+
+	// HTTP/2 is sent over the client's own connections to support
+	// h2c and to measure the SETTINGS and PING frames
+	switch {
+	case c.isHTTP3():
+		h3 = c.newH3RoundTripper()
+		tr = h3
+	case c.req.http2:
+		h2 = c.newH2RoundTripper()
+		tr = h2
+	}
+
+	httpClient := &http.Client{
+		Timeout:       c.req.timeoutHTTP,
+		Transport:     tr,
+		CheckRedirect: c.noRedirect,
+	}
+
+	// the redirects are followed hop by hop to measure each of them
+	if c.req.followRedirects > 0 {
+		httpClient.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+	}
+
+	req, err := c.newHTTPRequest(c.req.httpMethod, c.target, c.req.httpBody)
+	if err != nil {
+		return err
+	}
+
+	c.dials = 0
+	c.httpClient = httpClient
+	c.hops = c.hops[:0]
+	c.stats.HTTPRedirects = 0
+	c.stats.HTTP2Settings = 0
+	c.stats.HTTP2Ping = 0
+
+	var (
+		resp *http.Response
+		body []byte
+	)
+
+	for {
+		resp, body, err = c.roundTrip(httpClient, req)
+		if err != nil {
+			return err
+		}
+
+		if c.req.followRedirects < 1 || !isRedirect(resp.StatusCode) {
+			break
+		}
+
+		if c.stats.HTTPRedirects >= int64(c.req.followRedirects) {
+			return fmt.Errorf("%s stopped after %d redirects", c.target, c.req.followRedirects)
+		}
+
+		req, err = c.redirectRequest(req, resp)
+		if err != nil {
+			return err
+		}
+
+		c.stats.HTTPRedirects++
+	}
+
+	if h3 != nil {
+		h3.setStats()
+	}
+
+	if h2 != nil {
+		if err := h2.ping(c.req.timeout); err != nil {
+			return fmt.Errorf("%s HTTP/2 ping: %v", c.target, err)
+		}
+	}
+
+	if c.req.httpStatus != nil && !c.req.httpStatus.match(resp.StatusCode) {
+		c.stats.HTTPStatusMismatch++
+		return fmt.Errorf("%s unexpected status code: %d", c.target, resp.StatusCode)
+	}
+
+	if err := c.checkHTTPBody(body); err != nil {
+		c.stats.HTTPBodyCheckFailed++
+		return fmt.Errorf("%s %v", c.target, err)
+	}
+
+	return nil
+}
+
+// roundTrip sends the request, reads the response body and
+// records the hop if the redirects are followed
+func (c *client) roundTrip(httpClient *http.Client, req *http.Request) (*http.Response, []byte, error) {
+	var (
+		t      time.Time
+		reused bool
+		tm     httpTimings
+		h      = hop{URL: req.URL.String()}
+	)
+
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			reused = info.Reused
+			tm.set(&tm.gotConn)
+		},
+		WroteRequest: func(info httptrace.WroteRequestInfo) {
+			tm.set(&tm.wroteRequest)
+		},
+		GotFirstResponseByte: func() {
+			tm.set(&tm.firstByte)
+		},
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+
+	t = time.Now()
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	headers := time.Now()
+	c.stats.HTTPRequest = headers.Sub(t).Microseconds()
+
+	tm.Lock()
+	c.stats.HTTPWrite = duration(tm.gotConn, tm.wroteRequest)
+	c.stats.HTTPTTFB = duration(tm.wroteRequest, tm.firstByte)
+	c.stats.HTTPHeaders = duration(tm.firstByte, headers)
+	h.TTFB = duration(t, tm.firstByte)
+	tm.Unlock()
+
+	var (
+		body bytes.Buffer
+		w    = ioutil.Discard
+	)
+
+	// the body is kept only if it needs to be checked
+	if c.hasHTTPBodyCheck() {
+		w = &body
+	}
+
+	t = time.Now()
+	written, err := io.Copy(w, resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	c.stats.HTTPResponse = time.Since(t).Microseconds()
+
+	c.stats.HTTPThroughput = 0
+	if c.stats.HTTPResponse > 0 {
+		c.stats.HTTPThroughput = written * int64(time.Second/time.Microsecond) / c.stats.HTTPResponse
+	}
+
+	c.stats.HTTPStatusCode = resp.StatusCode
+	c.stats.HTTPRcvdBytes = written
+	c.stats.HTTPProto = resp.Proto
+
+	resp.Body.Close()
+
+	if c.req.followRedirects > 0 {
+		h.StatusCode = resp.StatusCode
+		if !reused {
+			h.DNSResolve = c.stats.DNSResolve
+			h.TCPConnect = c.stats.TCPConnect
+			h.TLSHandshake = c.stats.TLSHandshake
+		}
+		c.hops = append(c.hops, h)
+	}
+
+	return resp, body.Bytes(), nil
+}
+
+// httpTLSConfig returns the TLS config of the HTTP probes, the
+// ALPN offers h2 only if HTTP/2 has been requested
+func (c *client) httpTLSConfig(serverName string) *tls.Config {
+	config := c.tlsConfig(serverName)
+	config.NextProtos = []string{"http/1.1"}
+	if c.req.http2 {
+		config.NextProtos = []string{"h2", "http/1.1"}
+	}
+
+	return config
+}
+
+func (c *client) newHTTPRequest(method, target string, body []byte) (*http.Request, error) {
+	var r io.Reader
+
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	if len(body) > 0 {
+		r = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequest(method, target, r)
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range c.req.httpHeaders {
+		req.Header[k] = v
+	}
+
+	// the host header is taken from the request's Host field
+	if host := req.Header.Get("Host"); host != "" {
+		req.Host = host
+	}
+
+	return req, nil
+}
+
+// redirectRequest makes the next request based on the redirect
+// response the same way as the Go HTTP client does
+func (c *client) redirectRequest(req *http.Request, resp *http.Response) (*http.Request, error) {
+	loc, err := resp.Location()
+	if err != nil {
+		return nil, err
+	}
+
+	method, body := req.Method, c.req.httpBody
+	switch resp.StatusCode {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther:
+		if method != http.MethodGet && method != http.MethodHead {
+			method, body = http.MethodGet, nil
+		}
+	}
+
+	next, err := c.newHTTPRequest(method, loc.String(), body)
+	if err != nil {
+		return nil, err
+	}
+
+	// don't send the sensitive headers to the other hosts
+	if loc.Host != req.URL.Host {
+		for _, k := range []string{"Authorization", "Www-Authenticate", "Cookie", "Cookie2"} {
+			next.Header.Del(k)
+		}
+		next.Host = ""
+	}
+
+	return next, nil
+}
+
+func (c *client) hasHTTPBodyCheck() bool {
+	return c.req.httpBodyContains != "" || c.req.httpBodyRegex != nil || c.req.httpBodyJSON != nil
+}
+
+func (c *client) checkHTTPBody(b []byte) error {
+	if c.req.httpBodyContains != "" && !bytes.Contains(b, []byte(c.req.httpBodyContains)) {
+		return fmt.Errorf("response body doesn't contain %q", c.req.httpBodyContains)
+	}
+
+	if c.req.httpBodyRegex != nil && !c.req.httpBodyRegex.Match(b) {
+		return fmt.Errorf("response body doesn't match %q", c.req.httpBodyRegex.String())
+	}
+
+	if c.req.httpBodyJSON != nil {
+		return c.req.httpBodyJSON.check(b)
+	}
+
+	return nil
+}
+
+func (tm *httpTimings) set(t *time.Time) {
+	tm.Lock()
+	*t = time.Now()
+	tm.Unlock()
+}
+
+// duration returns the microseconds between the events
+// or zero if any of them hasn't happened
+func duration(from, to time.Time) int64 {
+	if from.IsZero() || to.IsZero() {
+		return 0
+	}
+
+	return to.Sub(from).Microseconds()
+}
+
+func (c *client) noRedirect(req *http.Request, via []*http.Request) error {
+	return fmt.Errorf("%s has been redirected", c.target)
+}
+
+func isRedirect(code int) bool {
+	switch code {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+
+	return false
+}
+
+func (s statusRanges) match(code int) bool {
+	for _, r := range s {
+		if code >= r.min && code <= r.max {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseHTTPStatus parses comma separated HTTP status codes
+// and ranges, e.g. 200,201,300-399
+func parseHTTPStatus(s string) (statusRanges, error) {
+	if len(s) < 1 {
+		return nil, nil
+	}
+
+	ranges := statusRanges{}
+	for _, item := range strings.Split(s, ",") {
+		var (
+			r   statusRange
+			err error
+		)
+
+		bounds := strings.SplitN(strings.TrimSpace(item), "-", 2)
+		r.min, err = strconv.Atoi(bounds[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid HTTP status: %s", item)
+		}
+
+		r.max = r.min
+		if len(bounds) > 1 {
+			r.max, err = strconv.Atoi(bounds[1])
+			if err != nil || r.max < r.min {
+				return nil, fmt.Errorf("invalid HTTP status range: %s", item)
+			}
+		}
+
+		ranges = append(ranges, r)
+	}
+
+	return ranges, nil
+}
+
+// parseHTTPHeaders parses the headers in "key: value" format
+func parseHTTPHeaders(headers []string) (http.Header, error) {
+	h := http.Header{}
+	for _, header := range headers {
+		kv := strings.SplitN(header, ":", 2)
+		if len(kv) != 2 || len(strings.TrimSpace(kv[0])) < 1 {
+			return nil, fmt.Errorf("invalid HTTP header: %s", header)
+		}
+		h.Add(strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1]))
+	}
+
+	return h, nil
+}
+
+// getHTTPBody returns the inline body or the content of the given file
+func getHTTPBody(body, filename string) ([]byte, error) {
+	if len(filename) > 0 {
+		return ioutil.ReadFile(filename)
+	}
+
+	return []byte(body), nil
+}
+
+// parseJSONCheck parses the JSON path equality check in
+// path=value format, e.g. data.items[0].status=ok
+func parseJSONCheck(s string) (*jsonCheck, error) {
+	if len(s) < 1 {
+		return nil, nil
+	}
+
+	kv := strings.SplitN(s, "=", 2)
+	if len(kv) != 2 {
+		return nil, fmt.Errorf("invalid JSON check: %s", s)
+	}
+
+	path := strings.TrimPrefix(strings.TrimPrefix(kv[0], "$"), ".")
+	path = strings.NewReplacer("[", ".", "]", "").Replace(path)
+	if len(path) < 1 {
+		return nil, fmt.Errorf("invalid JSON path: %s", kv[0])
+	}
+
+	return &jsonCheck{path: strings.Split(path, "."), value: kv[1]}, nil
+}
+
+func (j *jsonCheck) check(b []byte) error {
+	var v interface{}
+
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	if err := d.Decode(&v); err != nil {
+		return fmt.Errorf("response body is not JSON: %v", err)
+	}
+
+	for _, key := range j.path {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			v = node[key]
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return fmt.Errorf("JSON path %s not found", strings.Join(j.path, "."))
+			}
+			v = node[i]
+		default:
+			return fmt.Errorf("JSON path %s not found", strings.Join(j.path, "."))
+		}
+	}
+
+	value := fmt.Sprint(v)
+	switch v.(type) {
+	case map[string]interface{}, []interface{}:
+		b, _ := json.Marshal(v)
+		value = string(b)
+	case nil:
+		value = "null"
+	}
+
+	if value != j.value {
+		return fmt.Errorf("JSON path %s is %s, expected %s", strings.Join(j.path, "."), value, j.value)
+	}
+
+	return nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	tcpEstablished = 1
+	tcpCloseWait   = 8
+)
+
+func (r *request) isKeepAlive() bool {
+	return r.keepAliveIdle > 0 || r.keepAliveIntvl > 0 || r.keepAliveCnt > 0
+}
+
+// setKeepAlive sets the keepalive and user timeout socket options,
+// the dialer's keepalive is disabled so they aren't overridden
+func (c *client) setKeepAlive(fd int) {
+	if c.req.isKeepAlive() {
+		setSocketOptInt(fd, syscall.SOL_SOCKET, syscall.SO_KEEPALIVE, 1, false)
+		setSocketOptInt(fd, syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE, seconds(c.req.keepAliveIdle), false)
+		setSocketOptInt(fd, syscall.IPPROTO_TCP, syscall.TCP_KEEPINTVL, seconds(c.req.keepAliveIntvl), false)
+		setSocketOptInt(fd, syscall.IPPROTO_TCP, syscall.TCP_KEEPCNT, c.req.keepAliveCnt, false)
+	}
+
+	setSocketOptInt(fd, syscall.IPPROTO_TCP, unix.TCP_USER_TIMEOUT, int(c.req.userTimeout.Milliseconds()), false)
+}
+
+// checkPersistent returns an error if the connection has no TCP state
+// to follow, the HTTP/3 connection over UDP would be dropped each time
+func (c *client) checkPersistent() error {
+	if !c.req.persistent || !c.isHTTP3() {
+		return nil
+	}
+
+	return fmt.Errorf("%s persistent connection needs TCP, it doesn't apply to HTTP/3", c.target)
+}
+
+// persistent keeps a connection open and samples its TCP_INFO at
+// each interval, once the connection has been dropped e.g. by the
+// keepalive, user timeout or a middlebox it's reported and redialed
+func (c *client) persistent(ctx context.Context) {
+	var (
+		start     time.Time
+		connected bool
+		counter   = -1
+		wait      = c.getInterval(ctx)
+	)
+
+	defer func() {
+		if connected {
+			c.close()
+		}
+	}()
+
+	for counter < c.req.count-1 || c.req.count == 0 {
+		counter++
+
+		if counter != 0 {
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		if !connected {
+			if err := c.connect(ctx); err != nil {
+				if ctx.Err() == nil {
+					log.Println(err)
+				}
+				continue
+			}
+
+			connected, start = true, time.Now()
+
+			if err := c.exchange(); err != nil {
+				log.Println(err)
+			}
+		}
+
+		c.stats.ConnLifetime = time.Since(start).Microseconds()
+
+		if err := c.connState(); err != nil {
+			c.stats.ConnDropped++
+			log.Printf("%s connection has been dropped after %s: %v", c.target, time.Since(start).Round(time.Millisecond), err)
+			c.close()
+			connected = false
+		}
+
+		if c.req.grpc {
+			c.publish()
+		}
+
+		c.printer(counter)
+	}
+}
+
+// connState reads the TCP_INFO and returns an error
+// if the connection isn't established anymore
+func (c *client) connState() error {
+	if err := c.getTCPInfo(); err != nil {
+		return err
+	}
+
+	if c.stats.State == tcpEstablished {
+		return nil
+	}
+
+	if err := c.soError(); err != nil {
+		return err
+	}
+
+	if c.stats.State == tcpCloseWait {
+		return errors.New("closed by the peer")
+	}
+
+	return fmt.Errorf("TCP state %d", c.stats.State)
+}
+
+// soError returns the socket's pending error, e.g. ETIMEDOUT
+// once the keepalive probes or the user timeout have expired
+func (c *client) soError() error {
+	tcpConn, ok := c.conn.(*net.TCPConn)
+	if !ok {
+		return errors.New("tcp conn is nil")
+	}
+
+	rawConn, err := tcpConn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var soErr int
+	err = rawConn.Control(func(fd uintptr) {
+		soErr, err = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_ERROR)
+	})
+	if err != nil {
+		return err
+	}
+
+	if soErr != 0 {
+		return syscall.Errno(soErr)
+	}
+
+	return nil
+}
+
+func seconds(d time.Duration) int {
+	if d > 0 && d < time.Second {
+		return 1
+	}
+
+	return int(d / time.Second)
+}
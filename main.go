@@ -54,6 +54,23 @@ func main() {
 		return
 	}
 
+	if req.trace != nil {
+		trace(ctx, req.trace, targets)
+		return
+	}
+
+	if req.serve != nil {
+		s, err := newServer(req.serve)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if err := s.serve(ctx); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	tp := &tp{targets: make(map[string]prop)}
 
 	// command line targets
@@ -86,6 +103,11 @@ func main() {
 
 		go func(ctx context.Context, target target) {
 			defer wg.Done()
+			req, err := target.request(req)
+			if err != nil {
+				log.Println(err, target.Addr)
+				return
+			}
 			b, _ := json.Marshal(target.Labels)
 			ctx = context.WithValue(ctx, intervalKey, target.Interval)
 			ctx = context.WithValue(ctx, labelsKey, b)
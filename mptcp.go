@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	solMPTCP  = 284
+	mptcpInfo = 1
+)
+
+// mptcpInfoStruct represents the linux mptcp_info struct
+type mptcpInfoStruct struct {
+	Subflows         uint8
+	AddAddrSignal    uint8
+	AddAddrAccepted  uint8
+	SubflowsMax      uint8
+	AddAddrSignalMax uint8
+	AddAddrAcceptMax uint8
+	_                [2]byte
+	Flags            uint32
+	Token            uint32
+	WriteSeq         uint64
+	SndUna           uint64
+	RcvNxt           uint64
+	LocalAddrUsed    uint8
+	LocalAddrMax     uint8
+	CsumEnabled      uint8
+	_                uint8
+	Retransmits      uint32
+	BytesRetrans     uint64
+	BytesSent        uint64
+	BytesReceived    uint64
+	BytesAcked       uint64
+	SubflowsTotal    uint8
+	_                [7]byte
+}
+
+// getMPTCPInfo reports whether the connection fell back to
+// TCP, e.g. the server doesn't support MPTCP, otherwise it
+// reads the MPTCP level information of the connection
+func (c *client) getMPTCPInfo() error {
+	var info mptcpInfoStruct
+
+	tcpConn, ok := c.conn.(*net.TCPConn)
+	if !ok {
+		return nil
+	}
+
+	c.stats.MPTCPFallback = 1
+	c.setMPTCPStats(&info)
+
+	isMPTCP, err := tcpConn.MultipathTCP()
+	if err != nil || !isMPTCP {
+		return err
+	}
+
+	rawConn, err := tcpConn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	size := uint32(unsafe.Sizeof(info))
+
+	var e syscall.Errno
+	err = rawConn.Control(func(fd uintptr) {
+		_, _, e = syscall.Syscall6(syscall.SYS_GETSOCKOPT, fd, solMPTCP, mptcpInfo,
+			uintptr(unsafe.Pointer(&info)), uintptr(unsafe.Pointer(&size)), 0)
+	})
+	if err != nil {
+		return err
+	}
+	if e != 0 {
+		return fmt.Errorf("syscall err number=%d", e)
+	}
+
+	c.stats.MPTCPFallback = 0
+	c.setMPTCPStats(&info)
+
+	return nil
+}
+
+func (c *client) setMPTCPStats(info *mptcpInfoStruct) {
+	c.stats.MPTCPSubflows = info.Subflows
+	c.stats.MPTCPSubflowsMax = info.SubflowsMax
+	c.stats.MPTCPAddAddrSignal = info.AddAddrSignal
+	c.stats.MPTCPAddAddrAccepted = info.AddAddrAccepted
+	c.stats.MPTCPLocalAddrUsed = info.LocalAddrUsed
+	c.stats.MPTCPRetransmits = info.Retransmits
+	c.stats.MPTCPBytesSent = info.BytesSent
+	c.stats.MPTCPBytesReceived = info.BytesReceived
+	c.stats.MPTCPBytesAcked = info.BytesAcked
+}
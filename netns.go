@@ -0,0 +1,71 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+const netnsDir = "/var/run/netns"
+
+// netnsPath returns the network namespace's path, a name
+// refers to a namespace which is created by the ip netns
+func netnsPath(netns string) string {
+	if strings.Contains(netns, "/") {
+		return netns
+	}
+
+	return filepath.Join(netnsDir, netns)
+}
+
+// inNetns runs the function inside the network namespace, the
+// namespace is per thread so the function runs on a dedicated
+// locked OS thread and the sockets it creates stay in the namespace
+func inNetns(netns string, fn func() error) error {
+	if netns == "" {
+		return fn()
+	}
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		runtime.LockOSThread()
+		errCh <- runInNetns(netns, fn)
+	}()
+
+	return <-errCh
+}
+
+func runInNetns(netns string, fn func() error) error {
+	origin, err := os.Open("/proc/thread-self/ns/net")
+	if err != nil {
+		runtime.UnlockOSThread()
+		return err
+	}
+	defer origin.Close()
+
+	ns, err := os.Open(netnsPath(netns))
+	if err != nil {
+		runtime.UnlockOSThread()
+		return err
+	}
+	defer ns.Close()
+
+	if err := unix.Setns(int(ns.Fd()), unix.CLONE_NEWNET); err != nil {
+		runtime.UnlockOSThread()
+		return os.NewSyscallError("setns", err)
+	}
+
+	fnErr := fn()
+
+	// the thread is left locked if it can't be restored so
+	// the runtime terminates it once the goroutine exits
+	if err := unix.Setns(int(origin.Fd()), unix.CLONE_NEWNET); err == nil {
+		runtime.UnlockOSThread()
+	}
+
+	return fnErr
+}
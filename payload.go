@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxPayloadResponse limits the response buffer
+const maxPayloadResponse = 1 << 20
+
+// payloadConfig represents a target's send/expect payload
+type payloadConfig struct {
+	Send        string
+	SendHex     string `yaml:"send_hex"`
+	ExpectDelim string `yaml:"expect_delim"`
+	ExpectRegex string `yaml:"expect_regex"`
+	ExpectBytes int    `yaml:"expect_bytes"`
+}
+
+// setPayload parses and sets the payload's parameters, it overrides
+// only the given ones. the text values can have Go escape sequences
+func (r *request) setPayload(cfg *payloadConfig) error {
+	var err error
+
+	if cfg.Send != "" {
+		if r.payload, err = unescape(cfg.Send); err != nil {
+			return err
+		}
+	}
+
+	if cfg.SendHex != "" {
+		if r.payload, err = hex.DecodeString(strings.Replace(cfg.SendHex, " ", "", -1)); err != nil {
+			return err
+		}
+	}
+
+	if cfg.ExpectDelim != "" {
+		if r.expectDelim, err = unescape(cfg.ExpectDelim); err != nil {
+			return err
+		}
+	}
+
+	if cfg.ExpectRegex != "" {
+		if r.expectRegex, err = regexp.Compile(cfg.ExpectRegex); err != nil {
+			return err
+		}
+	}
+
+	if cfg.ExpectBytes > 0 {
+		r.expectBytes = cfg.ExpectBytes
+	}
+
+	return nil
+}
+
+func (c *client) hasPayload() bool {
+	return len(c.req.payload) > 0 || len(c.req.expectDelim) > 0 ||
+		c.req.expectRegex != nil || c.req.expectBytes > 0
+}
+
+// payloadExchange sends the payload if it's configured and reads the
+// response until the delimiter, the regular expression or the byte count
+func (c *client) payloadExchange(conn net.Conn) error {
+	c.stats.PayloadRTT = 0
+	c.stats.PayloadComplete = 0
+
+	conn.SetDeadline(time.Now().Add(c.req.timeout))
+	defer conn.SetDeadline(time.Time{})
+
+	t := time.Now()
+	if len(c.req.payload) > 0 {
+		if _, err := conn.Write(c.req.payload); err != nil {
+			return err
+		}
+	}
+
+	var (
+		buf   []byte
+		chunk = make([]byte, 4096)
+	)
+
+	for {
+		n, err := conn.Read(chunk)
+		if n > 0 {
+			if len(buf) == 0 {
+				c.stats.PayloadRTT = time.Since(t).Microseconds()
+			}
+
+			buf = append(buf, chunk[:n]...)
+			if c.isPayloadComplete(buf) {
+				c.stats.PayloadComplete = time.Since(t).Microseconds()
+				break
+			}
+		}
+
+		if err == nil && len(buf) > maxPayloadResponse {
+			err = fmt.Errorf("response exceeded %d bytes", maxPayloadResponse)
+		}
+
+		if err != nil {
+			c.stats.PayloadMatchFailed++
+			return fmt.Errorf("%s payload: %v", c.target, err)
+		}
+	}
+
+	if c.req.expectRegex != nil && !c.req.expectRegex.Match(buf) {
+		c.stats.PayloadMatchFailed++
+		return fmt.Errorf("%s payload: response doesn't match %q", c.target, c.req.expectRegex.String())
+	}
+
+	return nil
+}
+
+func (c *client) isPayloadComplete(buf []byte) bool {
+	switch {
+	case c.req.expectBytes > 0:
+		return len(buf) >= c.req.expectBytes
+	case len(c.req.expectDelim) > 0:
+		return bytes.Contains(buf, c.req.expectDelim)
+	case c.req.expectRegex != nil:
+		return c.req.expectRegex.Match(buf)
+	}
+
+	// the first received bytes without any expectation
+	return true
+}
+
+func unescape(s string) ([]byte, error) {
+	u, err := strconv.Unquote(`"` + strings.Replace(s, `"`, `\"`, -1) + `"`)
+	if err != nil {
+		return nil, fmt.Errorf("invalid escape sequence: %s", s)
+	}
+
+	return []byte(u), nil
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// pmtuSizes represents the IP packet sizes of the PMTU probe
+var pmtuSizes = []int{576, 1280, 1400, 1420, 1460, 1480, 1492, 1500, 4096, 9000}
+
+// pmtuAttempts is the number of times a size is tried before it's failed
+const pmtuAttempts = 3
+
+// pmtuStep represents the result of a packet size in the PMTU probe
+type pmtuStep struct {
+	Size     int
+	Mss      uint32
+	RTT      int64
+	Retrans  uint32
+	Pmtu     uint32
+	Attempts int
+	OK       bool
+}
+
+// pmtuProbe sends single DF segments of increasing size to the echo
+// service or the tcpprobe responder. each size is sent over a new
+// connection with the MSS clamped to the size so TCP doesn't segment
+// it. the kernel lowers the path MTU once an ICMP fragmentation needed
+// arrives, a black hole is a size that fails repeatedly while the
+// smaller sizes succeed.
+func (c *client) pmtuProbe() error {
+	c.pmtuSteps = c.pmtuSteps[:0]
+	c.stats.PMTUEffective = 0
+	c.stats.PMTUIPMtu = 0
+	c.stats.PMTUInterfaceMtu = 0
+	c.stats.PMTUMaxSize = 0
+	c.stats.PMTUBlackhole = 0
+	c.stats.PMTUBlackholeSize = 0
+
+	ifMTU, err := c.interfaceMTU()
+	if err != nil {
+		return fmt.Errorf("%s PMTU probe: %v", c.target, err)
+	}
+	c.stats.PMTUInterfaceMtu = uint32(ifMTU)
+
+	for _, size := range pmtuSizes {
+		if size > ifMTU {
+			break
+		}
+
+		step, err := c.pmtuStep(size)
+		if err != nil {
+			return fmt.Errorf("%s PMTU probe: %v", c.target, err)
+		}
+
+		// the peer's MSS limits the segment, the larger sizes can't be sent
+		if n := len(c.pmtuSteps); n > 0 && step.Mss <= c.pmtuSteps[n-1].Mss {
+			break
+		}
+
+		if step.Pmtu > 0 {
+			c.stats.PMTUEffective = step.Pmtu
+		}
+
+		// the kernel has learned a lower path MTU, e.g. from an ICMP
+		// fragmentation needed, and the segment has been resent smaller
+		if step.Pmtu < uint32(size) {
+			step.OK = false
+			c.pmtuSteps = append(c.pmtuSteps, step)
+			break
+		}
+
+		c.pmtuSteps = append(c.pmtuSteps, step)
+
+		if !step.OK {
+			if len(c.pmtuSteps) == 1 {
+				return fmt.Errorf("%s PMTU probe: no echo response at %d bytes", c.target, size)
+			}
+
+			c.stats.PMTUBlackhole = 1
+			c.stats.PMTUBlackholeSize = int64(size)
+			break
+		}
+
+		c.stats.PMTUMaxSize = int64(size)
+	}
+
+	c.stats.PMTUIPMtu, err = ipMTU(c.conn, c.isIPv4())
+	if err != nil {
+		return fmt.Errorf("%s PMTU probe: %v", c.target, err)
+	}
+
+	return nil
+}
+
+// pmtuStep tries a packet size up to the pmtuAttempts times
+func (c *client) pmtuStep(size int) (pmtuStep, error) {
+	var (
+		tcpInfo stats
+		step    = pmtuStep{Size: size}
+	)
+
+	mss := size - 40
+	if !c.isIPv4() {
+		mss = size - 60
+	}
+
+	for step.Attempts < pmtuAttempts && !step.OK {
+		step.Attempts++
+
+		conn, err := c.pmtuDial(mss)
+		if err != nil {
+			return step, err
+		}
+
+		if err := readTCPInfo(conn, &tcpInfo); err != nil {
+			conn.Close()
+			return step, err
+		}
+		step.Mss = tcpInfo.SndMss
+		retrans := tcpInfo.TotalRetrans
+
+		t := time.Now()
+		err = c.pmtuEcho(conn, int(step.Mss))
+		step.RTT = time.Since(t).Microseconds()
+
+		if err := readTCPInfo(conn, &tcpInfo); err != nil {
+			conn.Close()
+			return step, err
+		}
+		conn.Close()
+
+		step.Retrans += tcpInfo.TotalRetrans - retrans
+		step.Pmtu = tcpInfo.Pmtu
+
+		if e, ok := err.(net.Error); err != nil && !(ok && e.Timeout()) {
+			return step, err
+		}
+
+		step.OK = err == nil
+	}
+
+	return step, nil
+}
+
+// pmtuDial connects to the target with the given MSS, the socket
+// sets DF and follows the ICMP fragmentation needed messages
+func (c *client) pmtuDial(mss int) (net.Conn, error) {
+	var conn net.Conn
+
+	err := inNetns(c.req.netns, func() error {
+		srcAddr, err := getSrcAddr(c.req.srcAddr, c.isIPv4())
+		if err != nil {
+			return err
+		}
+
+		d := net.Dialer{
+			LocalAddr: srcAddr,
+			Timeout:   c.req.timeout,
+			Control: func(network, address string, rawConn syscall.RawConn) error {
+				if err := c.control(network, address, rawConn); err != nil {
+					return err
+				}
+				return rawConn.Control(func(fd uintptr) {
+					setSocketOptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_MAXSEG, mss, false)
+				})
+			},
+		}
+
+		conn, err = d.Dial("tcp", c.addr)
+
+		return err
+	})
+
+	return conn, err
+}
+
+// pmtuEcho sends a payload of the given size and reads it back
+// through the responder's echo command or a plain echo service
+func (c *client) pmtuEcho(conn net.Conn, size int) error {
+	conn.SetDeadline(time.Now().Add(c.req.timeout))
+	r := bufio.NewReader(conn)
+
+	if c.req.responder {
+		payload := "echo " + strings.Repeat("x", size-6) + "\n"
+		if _, err := io.WriteString(conn, payload); err != nil {
+			return err
+		}
+
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+
+		if len(line) != size-5 {
+			return fmt.Errorf("unexpected echo response size: %d", len(line))
+		}
+
+		return nil
+	}
+
+	if _, err := conn.Write(make([]byte, size)); err != nil {
+		return err
+	}
+
+	_, err := io.ReadFull(r, make([]byte, size))
+
+	return err
+}
+
+// interfaceMTU returns the MTU of the interface that
+// the probe's connection has been established through
+func (c *client) interfaceMTU() (int, error) {
+	var mtu int
+
+	addr, ok := c.conn.LocalAddr().(*net.TCPAddr)
+	if !ok {
+		return 0, errors.New("tcp conn is nil")
+	}
+
+	err := inNetns(c.req.netns, func() error {
+		ifaces, err := net.Interfaces()
+		if err != nil {
+			return err
+		}
+
+		for _, iface := range ifaces {
+			if c.req.soInterface != "" && iface.Name != c.req.soInterface {
+				continue
+			}
+
+			addrs, err := iface.Addrs()
+			if err != nil {
+				return err
+			}
+
+			for _, a := range addrs {
+				if ipNet, ok := a.(*net.IPNet); ok && ipNet.IP.Equal(addr.IP) {
+					mtu = iface.MTU
+					return nil
+				}
+			}
+		}
+
+		return fmt.Errorf("no interface has the address %s", addr.IP)
+	})
+
+	return mtu, err
+}
+
+// ipMTU returns the path MTU of the connection's route, IP_MTU
+func ipMTU(conn net.Conn, ipv4 bool) (uint32, error) {
+	var (
+		mtu  int
+		gErr error
+	)
+
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok || tcpConn == nil {
+		return 0, errors.New("tcp conn is nil")
+	}
+
+	rawConn, err := tcpConn.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+
+	err = rawConn.Control(func(fd uintptr) {
+		if ipv4 {
+			mtu, gErr = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MTU)
+		} else {
+			mtu, gErr = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_MTU)
+		}
+	})
+	if err != nil {
+		return 0, err
+	}
+	if gErr != nil {
+		return 0, os.NewSyscallError("getsockopt", gErr)
+	}
+
+	return uint32(mtu), nil
+}
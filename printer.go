@@ -42,6 +42,36 @@ func (c *client) printText(counter int) {
 		}
 	}
 	fmt.Println("")
+
+	if c.stats.server != nil {
+		fmt.Print("server: ")
+		s := reflect.ValueOf(c.stats.server).Elem()
+		for i := 0; i < s.NumField(); i++ {
+			f := s.Type().Field(i)
+			if f.Tag.Get("unexported") == "true" || !strings.HasPrefix(f.Tag.Get("name"), "tcpinfo_") {
+				continue
+			}
+			if _, ok := c.req.filter[strings.ToLower(f.Name)]; ok || filterLen == 0 {
+				fmt.Printf("%s:%v ", f.Name, s.Field(i).Interface())
+			}
+		}
+		fmt.Println("")
+	}
+
+	for i, h := range c.hops {
+		fmt.Printf("hop: %d url: %s StatusCode:%d DNSResolve:%d TCPConnect:%d TLSHandshake:%d TTFB:%d\n",
+			i, h.URL, h.StatusCode, h.DNSResolve, h.TCPConnect, h.TLSHandshake, h.TTFB)
+	}
+
+	for _, s := range c.pmtuSteps {
+		fmt.Printf("pmtu: %d Mss:%d RTT:%d Retrans:%d Pmtu:%d Attempts:%d OK:%t\n",
+			s.Size, s.Mss, s.RTT, s.Retrans, s.Pmtu, s.Attempts, s.OK)
+	}
+
+	for _, s := range c.samples {
+		fmt.Printf("sample: %d bytes: %d Rtt:%d Rttvar:%d SndCwnd:%d TotalRetrans:%d DeliveryRate:%d\n",
+			s.Time, s.Bytes, s.Rtt, s.Rttvar, s.SndCwnd, s.TotalRetrans, s.DeliveryRate)
+	}
 }
 
 func (c *client) printJSON(counter int, pretty bool) {
@@ -57,12 +87,24 @@ func (c *client) printJSON(counter int, pretty bool) {
 		Timestamp int64
 		Seq       int
 		stats
+		Hops    []hop                  `json:",omitempty"`
+		Samples []sample               `json:",omitempty"`
+		PMTU    []pmtuStep             `json:",omitempty"`
+		Server  map[string]interface{} `json:",omitempty"`
 	}{
 		c.target,
 		ip,
 		c.timestamp,
 		counter,
 		c.stats,
+		c.hops,
+		c.samples,
+		c.pmtuSteps,
+		nil,
+	}
+
+	if c.stats.server != nil {
+		d.Server = tcpInfoFields(c.stats.server)
 	}
 
 	if len(c.req.filter) > 0 {
@@ -6,6 +6,7 @@ import (
 	"log"
 	"reflect"
 	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -23,17 +24,17 @@ func (c *client) prometheus(ctx context.Context) {
 	for i := 0; i < v.NumField(); i++ {
 		i := i
 
-		if v.Type().Field(i).Tag.Get("unexported") == "true" {
+		if !c.isExported(v.Type().Field(i)) {
 			continue
 		}
 
 		switch v.Field(i).Kind() {
-		case reflect.Uint, reflect.Uint8, reflect.Uint32, reflect.Uint64:
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
 			f = func() float64 {
 				return float64(v.Field(i).Uint())
 			}
 
-		case reflect.Int, reflect.Int8, reflect.Int32, reflect.Int64:
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
 			f = func() float64 {
 				return float64(v.Field(i).Int())
 			}
@@ -45,14 +46,14 @@ func (c *client) prometheus(ctx context.Context) {
 			err = prometheus.Register(prometheus.NewCounterFunc(prometheus.CounterOpts{
 				Name:        "tp_" + v.Type().Field(i).Tag.Get("name"),
 				Help:        v.Type().Field(i).Tag.Get("help"),
-				ConstLabels: getLabels(ctx, c.target),
+				ConstLabels: c.labels(ctx),
 			}, f))
 
 		} else {
 			err = prometheus.Register(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
 				Name:        "tp_" + v.Type().Field(i).Tag.Get("name"),
 				Help:        v.Type().Field(i).Tag.Get("help"),
-				ConstLabels: getLabels(ctx, c.target),
+				ConstLabels: c.labels(ctx),
 			}, f))
 		}
 
@@ -61,6 +62,15 @@ func (c *client) prometheus(ctx context.Context) {
 		}
 	}
 
+	for _, collector := range c.infoCollectors(ctx) {
+		if err := prometheus.Register(collector); err != nil {
+			log.Println(err, c.target)
+		}
+	}
+
+	if err := prometheus.Register(c.serverCollector(ctx)); err != nil {
+		log.Println(err, c.target)
+	}
 }
 
 func (c *client) deprometheus(ctx context.Context) {
@@ -73,17 +83,17 @@ func (c *client) deprometheus(ctx context.Context) {
 	for i := 0; i < v.NumField(); i++ {
 		i := i
 
-		if v.Type().Field(i).Tag.Get("unexported") == "true" {
+		if !c.isExported(v.Type().Field(i)) {
 			continue
 		}
 
 		switch v.Field(i).Kind() {
-		case reflect.Uint, reflect.Uint8, reflect.Uint32, reflect.Uint64:
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
 			f = func() float64 {
 				return float64(v.Field(i).Uint())
 			}
 
-		case reflect.Int, reflect.Int8, reflect.Int32, reflect.Int64:
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
 			f = func() float64 {
 				return float64(v.Field(i).Int())
 			}
@@ -94,12 +104,12 @@ func (c *client) deprometheus(ctx context.Context) {
 		if v.Type().Field(i).Tag.Get("kind") == "counter" {
 			ok = prometheus.Unregister(prometheus.NewCounterFunc(prometheus.CounterOpts{
 				Name:        "tp_" + v.Type().Field(i).Tag.Get("name"),
-				ConstLabels: getLabels(ctx, c.target),
+				ConstLabels: c.labels(ctx),
 			}, f))
 		} else {
 			ok = prometheus.Unregister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
 				Name:        "tp_" + v.Type().Field(i).Tag.Get("name"),
-				ConstLabels: getLabels(ctx, c.target),
+				ConstLabels: c.labels(ctx),
 			}, f))
 		}
 
@@ -107,6 +117,139 @@ func (c *client) deprometheus(ctx context.Context) {
 			log.Println("prometheus unregister failed:", c.target)
 		}
 	}
+
+	for _, collector := range c.infoCollectors(ctx) {
+		if ok := prometheus.Unregister(collector); !ok {
+			log.Println("prometheus unregister failed:", c.target)
+		}
+	}
+
+	if ok := prometheus.Unregister(c.serverCollector(ctx)); !ok {
+		log.Println("prometheus unregister failed:", c.target)
+	}
+}
+
+// probeMetrics maps the metric name prefixes to the probes they apply
+// to, the other targets would report them as zero e.g. a failed protocol
+// check, a not serving gRPC service or a fallback from MPTCP
+var probeMetrics = map[string]func(c *client) bool{
+	"mptcp_": func(c *client) bool { return c.req.mptcp },
+	"tfo_":   func(c *client) bool { return c.req.soTCPFastOpen },
+	"pmtu_":  func(c *client) bool { return c.req.pmtu },
+	"bulk_":  (*client).isBulk,
+	"quic_":  (*client).isHTTP3,
+	"ws_":    (*client).isWebSocket,
+	"grpc_":  (*client).isGRPC,
+	"proto_": func(c *client) bool { return c.getChecker() != nil },
+}
+
+// isExported returns true if the stats field is exported
+// and it applies to the client's probe
+func (c *client) isExported(f reflect.StructField) bool {
+	switch {
+	case f.Tag.Get("unexported") == "true":
+		return false
+	case f.Name == "TCPConnect" && c.req.soTCPFastOpen:
+		// the TCP Fast Open connect returns before the handshake
+		// once the cookie is cached, see tfo_connect_response
+		return false
+	case f.Name == "ECNRequested" && !c.req.ecn:
+		return false
+	}
+
+	for prefix, applies := range probeMetrics {
+		if strings.HasPrefix(f.Tag.Get("name"), prefix) && !applies(c) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// infoCollector exposes the string stats with the same info
+// tag as the labels of an info-style metric
+type infoCollector struct {
+	client *client
+	desc   *prometheus.Desc
+	fields []int
+}
+
+func (c *client) infoCollectors(ctx context.Context) []*infoCollector {
+	var (
+		collectors []*infoCollector
+		infos      = map[string]*infoCollector{}
+		labels     = map[string][]string{}
+	)
+
+	t := reflect.TypeOf(c.stats)
+	for i := 0; i < t.NumField(); i++ {
+		info := t.Field(i).Tag.Get("info")
+		if info == "" || t.Field(i).Type.Kind() != reflect.String {
+			continue
+		}
+
+		if _, ok := infos[info]; !ok {
+			infos[info] = &infoCollector{client: c}
+			collectors = append(collectors, infos[info])
+		}
+
+		infos[info].fields = append(infos[info].fields, i)
+		labels[info] = append(labels[info], t.Field(i).Tag.Get("name"))
+	}
+
+	for info, collector := range infos {
+		collector.desc = prometheus.NewDesc(
+			"tp_"+info+"_info",
+			info+" information",
+			labels[info],
+			c.labels(ctx),
+		)
+	}
+
+	return collectors
+}
+
+func (i *infoCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- i.desc
+}
+
+func (i *infoCollector) Collect(ch chan<- prometheus.Metric) {
+	var (
+		values []string
+		empty  = true
+	)
+
+	v := reflect.ValueOf(&i.client.stats).Elem()
+	for _, f := range i.fields {
+		values = append(values, v.Field(f).String())
+		if v.Field(f).String() != "" {
+			empty = false
+		}
+	}
+
+	// nothing to expose, e.g. TLS info for a plain TCP target
+	if empty {
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(i.desc, prometheus.GaugeValue, 1, values...)
+}
+
+// labels returns the target's labels, the network namespace,
+// interface and fwmark which the target is probed through, they
+// are always present to keep the same label names for all targets
+func (c *client) labels(ctx context.Context) prometheus.Labels {
+	labels := getLabels(ctx, c.target)
+
+	labels["netns"] = c.req.netns
+	labels["interface"] = c.req.soInterface
+	labels["fwmark"] = ""
+
+	if c.req.soMark != 0 {
+		labels["fwmark"] = strconv.Itoa(c.req.soMark)
+	}
+
+	return labels
 }
 
 func getLabels(ctx context.Context, target string) prometheus.Labels {
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// serverCollector exposes the responder's TCP_INFO of the
+// connection as tp_server_tcpinfo_* metrics
+type serverCollector struct {
+	client *client
+	descs  map[int]*prometheus.Desc
+}
+
+// getServerTCPInfo fetches the responder's TCP_INFO of the same
+// connection through the tcpinfo command or the /tcpinfo endpoint
+func (c *client) getServerTCPInfo() error {
+	var (
+		b   []byte
+		err error
+	)
+
+	c.stats.server = nil
+
+	switch {
+	case c.isBulk():
+		b, err = c.ctrlServerTCPInfo()
+	case c.scheme() == "http" || c.scheme() == "https":
+		b, err = c.httpServerTCPInfo()
+	default:
+		b, err = c.lineServerTCPInfo()
+	}
+
+	if err != nil {
+		c.stats.ResponderError++
+		return fmt.Errorf("%s responder: %v", c.target, err)
+	}
+
+	s := &stats{}
+	if err := json.Unmarshal(b, s); err != nil {
+		c.stats.ResponderError++
+		return fmt.Errorf("%s responder: %v", c.target, err)
+	}
+
+	c.stats.server = s
+
+	return nil
+}
+
+func (c *client) lineServerTCPInfo() ([]byte, error) {
+	var rw io.ReadWriter = c.conn
+	if c.tlsConn != nil {
+		rw = c.tlsConn
+	}
+
+	c.conn.SetDeadline(time.Now().Add(c.req.timeout))
+	defer c.conn.SetDeadline(time.Time{})
+
+	return lineTCPInfo(rw, "tcpinfo\n")
+}
+
+// ctrlServerTCPInfo fetches the TCP_INFO over a control connection,
+// the probe's connection can't carry the request once a bulk transfer,
+// e.g. an unlimited download, has been started. the responder finds
+// the probe's connection by its remote address.
+func (c *client) ctrlServerTCPInfo() ([]byte, error) {
+	conn, err := c.dialCtrl()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(c.req.timeout))
+	addr := c.conn.LocalAddr().String()
+
+	if c.scheme() != "http" && c.scheme() != "https" {
+		return lineTCPInfo(conn, "tcpinfo "+addr+"\n")
+	}
+
+	req, err := http.NewRequest(http.MethodGet, c.target, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.URL.Path = "/tcpinfo"
+	req.URL.RawQuery = url.Values{"conn": {addr}}.Encode()
+	req.Close = true
+
+	if err := req.Write(conn); err != nil {
+		return nil, err
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	return ioutil.ReadAll(resp.Body)
+}
+
+// dialCtrl connects to the target's address next to the probe's connection
+func (c *client) dialCtrl() (net.Conn, error) {
+	var (
+		conn net.Conn
+		err  error
+	)
+
+	d := net.Dialer{Timeout: c.req.timeout}
+	err = inNetns(c.req.netns, func() error {
+		conn, err = d.Dial("tcp", c.addr)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if c.scheme() != "https" {
+		return conn, nil
+	}
+
+	tlsConn := tls.Client(conn, c.tlsConfig(c.serverName()))
+	tlsConn.SetDeadline(time.Now().Add(c.req.timeout))
+	if err := tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return tlsConn, nil
+}
+
+// lineTCPInfo sends the tcpinfo command and reads the JSON line
+func lineTCPInfo(rw io.ReadWriter, cmd string) ([]byte, error) {
+	if _, err := io.WriteString(rw, cmd); err != nil {
+		return nil, err
+	}
+
+	line, err := bufio.NewReader(rw).ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.HasPrefix(line, "error") {
+		return nil, errors.New(strings.TrimSpace(line))
+	}
+
+	return []byte(line), nil
+}
+
+// httpServerTCPInfo requests the /tcpinfo endpoint, the request
+// has to be sent over the probe's connection
+func (c *client) httpServerTCPInfo() ([]byte, error) {
+	if c.httpClient == nil {
+		return nil, errors.New("no HTTP connection")
+	}
+
+	req, err := http.NewRequest(http.MethodGet, c.target, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.URL.Path = "/tcpinfo"
+	req.URL.RawQuery = ""
+
+	reused := false
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			reused = info.Reused
+		},
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if !reused {
+		return nil, errors.New("the probe's connection has not been reused")
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	return ioutil.ReadAll(resp.Body)
+}
+
+func (c *client) serverCollector(ctx context.Context) *serverCollector {
+	collector := &serverCollector{client: c, descs: map[int]*prometheus.Desc{}}
+
+	t := reflect.TypeOf(c.stats)
+	for i := 0; i < t.NumField(); i++ {
+		name := t.Field(i).Tag.Get("name")
+		if t.Field(i).Tag.Get("unexported") == "true" || !strings.HasPrefix(name, "tcpinfo_") {
+			continue
+		}
+
+		collector.descs[i] = prometheus.NewDesc(
+			"tp_server_"+name,
+			strings.TrimSpace("responder "+t.Field(i).Tag.Get("help")),
+			nil,
+			c.labels(ctx),
+		)
+	}
+
+	return collector
+}
+
+func (s *serverCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range s.descs {
+		ch <- desc
+	}
+}
+
+func (s *serverCollector) Collect(ch chan<- prometheus.Metric) {
+	server := s.client.stats.server
+	if server == nil {
+		return
+	}
+
+	v := reflect.ValueOf(server).Elem()
+	for i, desc := range s.descs {
+		var value float64
+
+		switch v.Field(i).Kind() {
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			value = float64(v.Field(i).Uint())
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			value = float64(v.Field(i).Int())
+		}
+
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value)
+	}
+}
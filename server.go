@@ -0,0 +1,344 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// serveReq represents the responder's parameters
+type serveReq struct {
+	addr        string
+	http        bool
+	cert        string
+	key         string
+	quiet       bool
+	mptcp       bool
+	maxDuration time.Duration
+}
+
+// server represents the tcpprobe responder, it answers the
+// echo, discard, download, upload and tcpinfo requests over
+// a line based protocol or HTTP and optionally TLS
+type server struct {
+	req       *serveReq
+	ln        net.Listener
+	tlsConfig *tls.Config
+	// conns holds the open connections by the remote address
+	// so the TCP_INFO can be fetched over another connection
+	conns sync.Map
+}
+
+type connContextKey string
+
+const (
+	serveReadHeaderTimeout = 10 * time.Second
+	serveIdleTimeout       = time.Minute
+)
+
+const serveDescription = `the line based commands over TCP or TLS:
+   echo <text>       responds the text
+   discard           reads and discards until the connection is closed
+   download <bytes>  sends the given bytes [0 is unlimited]
+   upload <bytes>    reads the given bytes and responds the received bytes
+   tcpinfo [addr]    responds the server side TCP_INFO in JSON format of the
+                     connection or the connection from the given remote address
+
+   the HTTP endpoints: /echo, /discard, /download?size=<bytes>, /upload and /tcpinfo[?conn=<addr>]
+
+examples:
+   tcpprobe serve -addr :8083
+   tcpprobe -payload "download 0\n" -bulk-duration 10s 192.168.10.1:8083
+   tcpprobe serve -http -cert server.crt -key server.key`
+
+var connKey connContextKey
+
+func newServer(r *serveReq) (*server, error) {
+	s := &server{req: r}
+
+	if r.cert != "" || r.key != "" {
+		cert, err := tls.LoadX509KeyPair(r.cert, r.key)
+		if err != nil {
+			return nil, err
+		}
+		s.tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+
+	lc := net.ListenConfig{}
+	lc.SetMultipathTCP(r.mptcp)
+
+	ln, err := lc.Listen(context.Background(), "tcp", r.addr)
+	if err != nil {
+		return nil, err
+	}
+	s.ln = ln
+
+	return s, nil
+}
+
+func (s *server) serve(ctx context.Context) error {
+	go func() {
+		<-ctx.Done()
+		s.ln.Close()
+	}()
+
+	log.Printf("tcpprobe server has been started on %s", s.ln.Addr())
+
+	if s.req.http {
+		return s.serveHTTP(ctx)
+	}
+
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		go s.handle(conn)
+	}
+}
+
+// handle runs the line based commands of the connection
+func (s *server) handle(conn net.Conn) {
+	defer conn.Close()
+	defer s.printTCPInfo(conn)
+
+	s.conns.Store(conn.RemoteAddr().String(), conn)
+	defer s.conns.Delete(conn.RemoteAddr().String())
+
+	var rw io.ReadWriter = conn
+	if s.tlsConfig != nil {
+		rw = tls.Server(conn, s.tlsConfig)
+	}
+
+	r := bufio.NewReader(rw)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		if err := s.command(rw, r, conn, strings.TrimSpace(line)); err != nil {
+			return
+		}
+	}
+}
+
+func (s *server) command(w io.Writer, r *bufio.Reader, conn net.Conn, line string) error {
+	var err error
+
+	fields := strings.Fields(line)
+	if len(fields) < 1 {
+		return nil
+	}
+
+	switch strings.ToLower(fields[0]) {
+	case "echo":
+		_, err = fmt.Fprintln(w, strings.TrimSpace(line[len(fields[0]):]))
+	case "discard":
+		if _, err = io.Copy(ioutil.Discard, r); err == nil {
+			err = io.EOF
+		}
+	case "download":
+		err = download(w, sizeArg(fields))
+	case "upload":
+		var n int64
+		if size := sizeArg(fields); size > 0 {
+			n, err = io.CopyN(ioutil.Discard, r, size)
+		} else {
+			n, err = io.Copy(ioutil.Discard, r)
+		}
+		if err == nil {
+			_, err = fmt.Fprintln(w, n)
+		}
+	case "tcpinfo":
+		var b []byte
+		if len(fields) > 1 {
+			conn, err = s.lookupConn(fields[1])
+		}
+		if err == nil {
+			b, err = serverTCPInfo(conn)
+		}
+		if err != nil {
+			_, err = fmt.Fprintf(w, "error %v\n", err)
+		} else {
+			_, err = fmt.Fprintf(w, "%s\n", b)
+		}
+	default:
+		_, err = fmt.Fprintf(w, "error unknown command: %s\n", fields[0])
+	}
+
+	return err
+}
+
+func (s *server) serveHTTP(ctx context.Context) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/echo", func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(w, r.Body)
+	})
+	mux.HandleFunc("/discard", func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(ioutil.Discard, r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("/download", func(w http.ResponseWriter, r *http.Request) {
+		size, _ := strconv.ParseInt(r.URL.Query().Get("size"), 10, 64)
+		if size > 0 {
+			w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+		}
+		// the download, e.g. an unlimited one, ends at the max duration
+		if s.req.maxDuration > 0 {
+			http.NewResponseController(w).SetWriteDeadline(time.Now().Add(s.req.maxDuration))
+		}
+		download(w, size)
+	})
+	mux.HandleFunc("/upload", func(w http.ResponseWriter, r *http.Request) {
+		n, _ := io.Copy(ioutil.Discard, r.Body)
+		fmt.Fprintln(w, n)
+	})
+	mux.HandleFunc("/tcpinfo", func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		conn, _ := r.Context().Value(connKey).(net.Conn)
+		if addr := r.URL.Query().Get("conn"); addr != "" {
+			if conn, err = s.lookupConn(addr); err != nil {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+		}
+
+		b, err := serverTCPInfo(conn)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, "%s\n", b)
+	})
+
+	// the read timeout bounds the uploads to the max duration
+	srv := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: serveReadHeaderTimeout,
+		ReadTimeout:       s.req.maxDuration,
+		IdleTimeout:       serveIdleTimeout,
+		ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+			return context.WithValue(ctx, connKey, netConn(c))
+		},
+		ConnState: func(c net.Conn, state http.ConnState) {
+			switch state {
+			case http.StateNew:
+				s.conns.Store(c.RemoteAddr().String(), netConn(c))
+			case http.StateHijacked, http.StateClosed:
+				s.conns.Delete(c.RemoteAddr().String())
+			}
+		},
+	}
+
+	ln := s.ln
+	if s.tlsConfig != nil {
+		ln = tls.NewListener(ln, s.tlsConfig)
+	}
+
+	err := srv.Serve(ln)
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	return err
+}
+
+// lookupConn returns the open connection from the given remote address
+func (s *server) lookupConn(addr string) (net.Conn, error) {
+	conn, ok := s.conns.Load(addr)
+	if !ok {
+		return nil, fmt.Errorf("connection from %s not found", addr)
+	}
+
+	return conn.(net.Conn), nil
+}
+
+// netConn returns the underlying TCP connection of a TLS connection
+func netConn(c net.Conn) net.Conn {
+	if tlsConn, ok := c.(*tls.Conn); ok {
+		return tlsConn.NetConn()
+	}
+
+	return c
+}
+
+// printTCPInfo prints the server side TCP_INFO of the connection
+func (s *server) printTCPInfo(conn net.Conn) {
+	if s.req.quiet {
+		return
+	}
+
+	b, err := serverTCPInfo(conn)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	fmt.Printf("remote: %s tcpinfo: %s\n", conn.RemoteAddr(), b)
+}
+
+// serverTCPInfo returns the connection's TCP_INFO in JSON format
+func serverTCPInfo(conn net.Conn) ([]byte, error) {
+	var s stats
+	if err := readTCPInfo(conn, &s); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(tcpInfoFields(&s))
+}
+
+// tcpInfoFields returns the TCP_INFO fields of the stats
+func tcpInfoFields(s *stats) map[string]interface{} {
+	m := map[string]interface{}{}
+
+	v := reflect.ValueOf(s).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		if f.Tag.Get("unexported") == "true" || !strings.HasPrefix(f.Tag.Get("name"), "tcpinfo_") {
+			continue
+		}
+		m[f.Name] = v.Field(i).Interface()
+	}
+
+	return m
+}
+
+// download writes the given bytes or until the peer goes away
+func download(w io.Writer, size int64) error {
+	if size > 0 {
+		_, err := io.CopyN(w, zeroReader{}, size)
+		return err
+	}
+
+	_, err := io.Copy(w, zeroReader{})
+
+	return err
+}
+
+func sizeArg(fields []string) int64 {
+	if len(fields) < 2 {
+		return 0
+	}
+
+	size, _ := strconv.ParseInt(fields[1], 10, 64)
+
+	return size
+}
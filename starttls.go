@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// starttlsProtos represents the protocol specific plaintext
+// negotiations before upgrading the connection to TLS
+var starttlsProtos = map[string]func(conn net.Conn) error{
+	"smtp":     starttlsSMTP,
+	"imap":     starttlsIMAP,
+	"pop3":     starttlsPOP3,
+	"ftp":      starttlsFTP,
+	"postgres": starttlsPostgres,
+}
+
+// starttlsProto returns the requested STARTTLS protocol
+// or the one which is detected by the target's scheme
+func (c *client) starttlsProto() string {
+	if c.req.starttls != "" {
+		return c.req.starttls
+	}
+
+	switch c.scheme() {
+	case "smtp", "imap", "pop3", "ftp":
+		return c.scheme()
+	}
+
+	return ""
+}
+
+func (c *client) startTLS() error {
+	negotiate, ok := starttlsProtos[c.starttlsProto()]
+	if !ok {
+		return fmt.Errorf("starttls %s doesn't support", c.starttlsProto())
+	}
+
+	c.conn.SetDeadline(time.Now().Add(c.req.timeout))
+	defer c.conn.SetDeadline(time.Time{})
+
+	t := time.Now()
+	if err := negotiate(c.conn); err != nil {
+		return fmt.Errorf("%s starttls: %v", c.target, err)
+	}
+	c.stats.STARTTLSNegotiation = time.Since(t).Microseconds()
+
+	tlsConn, err := c.tlsHandshake(c.conn, c.tlsConfig(c.serverName()))
+	if err != nil {
+		return err
+	}
+
+	c.closers = append(c.closers, tlsConn)
+
+	return nil
+}
+
+func starttlsSMTP(conn net.Conn) error {
+	tp := textproto.NewConn(conn)
+
+	if _, _, err := tp.ReadResponse(220); err != nil {
+		return err
+	}
+
+	if err := tp.PrintfLine("EHLO tcpprobe"); err != nil {
+		return err
+	}
+
+	if _, _, err := tp.ReadResponse(250); err != nil {
+		return err
+	}
+
+	if err := tp.PrintfLine("STARTTLS"); err != nil {
+		return err
+	}
+
+	_, _, err := tp.ReadResponse(220)
+
+	return err
+}
+
+func starttlsFTP(conn net.Conn) error {
+	tp := textproto.NewConn(conn)
+
+	if _, _, err := tp.ReadResponse(220); err != nil {
+		return err
+	}
+
+	if err := tp.PrintfLine("AUTH TLS"); err != nil {
+		return err
+	}
+
+	_, _, err := tp.ReadResponse(234)
+
+	return err
+}
+
+func starttlsIMAP(conn net.Conn) error {
+	r := bufio.NewReader(conn)
+
+	if err := expectLine(r, "* OK"); err != nil {
+		return err
+	}
+
+	if _, err := fmt.Fprint(conn, "a001 STARTTLS\r\n"); err != nil {
+		return err
+	}
+
+	// skip the untagged responses
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+
+		if strings.HasPrefix(line, "a001 ") {
+			if !strings.HasPrefix(line, "a001 OK") {
+				return fmt.Errorf("unexpected response: %s", strings.TrimSpace(line))
+			}
+			return nil
+		}
+	}
+}
+
+func starttlsPOP3(conn net.Conn) error {
+	r := bufio.NewReader(conn)
+
+	if err := expectLine(r, "+OK"); err != nil {
+		return err
+	}
+
+	if _, err := fmt.Fprint(conn, "STLS\r\n"); err != nil {
+		return err
+	}
+
+	return expectLine(r, "+OK")
+}
+
+func starttlsPostgres(conn net.Conn) error {
+	// SSLRequest: length 8 and the request code 80877103
+	if _, err := conn.Write([]byte{0, 0, 0, 8, 0x04, 0xd2, 0x16, 0x2f}); err != nil {
+		return err
+	}
+
+	b := make([]byte, 1)
+	if _, err := conn.Read(b); err != nil {
+		return err
+	}
+
+	if b[0] != 'S' {
+		return fmt.Errorf("server doesn't support SSL")
+	}
+
+	return nil
+}
+
+func expectLine(r *bufio.Reader, prefix string) error {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return err
+	}
+
+	if !strings.HasPrefix(line, prefix) {
+		return fmt.Errorf("unexpected response: %s", strings.TrimSpace(line))
+	}
+
+	return nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// tcpFastOpenConnect represents the TCP_FASTOPEN_CONNECT socket
+// option, the connect returns immediately and the SYN carries
+// the first write's data once the server's TFO cookie is cached
+const tcpFastOpenConnect = 0x1e
+
+// the TCP_INFO tcpi_options bits
+const (
+	tcpOptTimestamps = 0x01
+	tcpOptSack       = 0x02
+	tcpOptWscale     = 0x04
+	tcpOptECN        = 0x08
+	tcpOptECNSeen    = 0x10
+	tcpOptSynData    = 0x20
+	tcpOptUsecTS     = 0x40
+)
+
+// tcpOptionNames represents the TCP_INFO tcpi_options bits
+var tcpOptionNames = []struct {
+	bit  uint8
+	name string
+}{
+	{tcpOptTimestamps, "timestamps"},
+	{tcpOptSack, "sack"},
+	{tcpOptWscale, "wscale"},
+	{tcpOptECN, "ecn"},
+	{tcpOptECNSeen, "ecn_seen"},
+	{tcpOptSynData, "syn_data"},
+	{tcpOptUsecTS, "usec_ts"},
+}
+
+// tcpOptions returns the names of the negotiated TCP options
+func tcpOptions(options uint8) string {
+	var names []string
+
+	for _, o := range tcpOptionNames {
+		if options&o.bit != 0 {
+			names = append(names, o.name)
+		}
+	}
+
+	return strings.Join(names, ",")
+}
+
+// checkTFO returns an error if the server speaks first, once the cookie
+// is cached the connect returns before the handshake and the SYN waits
+// for the first write, it would wait until the timeout for the server
+func (c *client) checkTFO() error {
+	if !c.req.soTCPFastOpen || c.writesFirst() {
+		return nil
+	}
+
+	return fmt.Errorf("%s TCP Fast Open needs an exchange that the client writes first", c.target)
+}
+
+// writesFirst returns true if the client sends the first
+// bytes of the exchange over the probe's connection
+func (c *client) writesFirst() bool {
+	switch {
+	case c.isHTTP3(), c.req.pmtu:
+		return false
+	case c.isBulk():
+		return len(c.req.payload) > 0 || c.req.bulkUpload
+	case c.scheme() == "http" || c.scheme() == "https":
+		return true
+	case c.starttlsProto() != "":
+		return c.starttlsProto() == "postgres"
+	case c.isTLS(), c.isWebSocket(), c.isGRPC():
+		return true
+	case c.getChecker() != nil:
+		return c.scheme() == "redis" || c.scheme() == "postgres"
+	}
+
+	return len(c.req.payload) > 0
+}
+
+// setTFOStats reports whether the SYN data has been acknowledged and
+// how much of the connect to first response time it saved compared
+// with the last connection which has been made without the SYN data
+func (c *client) setTFOStats() {
+	c.stats.TFOSynData = uint8(boolToInt(c.stats.Options&tcpOptSynData != 0))
+	c.stats.TFOConnectResponse = c.stats.TCPConnect + c.stats.TLSHandshake + c.firstResponse()
+	c.stats.TFOSaved = 0
+
+	if c.stats.TFOSynData == 0 {
+		c.tfoBaseline = c.stats.TFOConnectResponse
+		return
+	}
+
+	if c.tfoBaseline > 0 {
+		c.stats.TFOSaved = c.tfoBaseline - c.stats.TFOConnectResponse
+	}
+}
+
+// firstResponse returns the time from the first write
+// to the first response byte of the exchange
+func (c *client) firstResponse() int64 {
+	if c.stats.PayloadRTT > 0 {
+		return c.stats.PayloadRTT
+	}
+
+	return c.stats.HTTPWrite + c.stats.HTTPTTFB
+}
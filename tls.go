@@ -0,0 +1,140 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+	"time"
+)
+
+var tlsVersions = map[uint16]string{
+	tls.VersionTLS10: "TLS 1.0",
+	tls.VersionTLS11: "TLS 1.1",
+	tls.VersionTLS12: "TLS 1.2",
+	tls.VersionTLS13: "TLS 1.3",
+}
+
+func (c *client) tlsConfig(serverName string) *tls.Config {
+	return &tls.Config{
+		InsecureSkipVerify: c.req.insecure,
+		ServerName:         serverName,
+		ClientSessionCache: c.tlsSessionCache,
+		Certificates:       c.req.tlsCerts,
+		RootCAs:            c.req.tlsRootCAs,
+	}
+}
+
+// isTLS returns true if the target is a raw TLS service
+func (c *client) isTLS() bool {
+	switch c.scheme() {
+	case "tls", "ldaps", "imaps", "pop3s", "smtps":
+		return true
+	}
+
+	return false
+}
+
+// tlsProbe performs only the TLS handshake without any application layer
+func (c *client) tlsProbe() error {
+	c.conn.SetDeadline(time.Now().Add(c.req.timeout))
+	defer c.conn.SetDeadline(time.Time{})
+
+	tlsConn, err := c.tlsHandshake(c.conn, c.tlsConfig(c.serverName()))
+	if err != nil {
+		return err
+	}
+
+	c.closers = append(c.closers, tlsConn)
+	c.tlsConn = tlsConn
+
+	if c.hasPayload() {
+		return c.payloadExchange(tlsConn)
+	}
+
+	return nil
+}
+
+// tlsHandshake runs the TLS handshake on the given connection
+// and records the handshake duration and the session's metadata
+func (c *client) tlsHandshake(conn net.Conn, config *tls.Config) (*tls.Conn, error) {
+	c.setTLSStats(tls.ConnectionState{})
+
+	tlsConn := tls.Client(conn, config)
+
+	t := time.Now()
+	err := tlsConn.Handshake()
+	c.stats.TLSHandshake = time.Since(t).Microseconds()
+
+	if err != nil {
+		c.stats.TLSHandshakeError++
+		return tlsConn, err
+	}
+
+	c.setTLSStats(tlsConn.ConnectionState())
+
+	return tlsConn, nil
+}
+
+// setTLS loads the client certificate and the CA bundle, the key
+// is read from the certificate file if it's not specified
+func (r *request) setTLS(cert, key, caCert string) error {
+	if cert != "" {
+		if key == "" {
+			key = cert
+		}
+
+		keyPair, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return err
+		}
+
+		r.tlsCerts = []tls.Certificate{keyPair}
+	}
+
+	if caCert != "" {
+		b, err := ioutil.ReadFile(caCert)
+		if err != nil {
+			return err
+		}
+
+		pool := x509.NewCertPool()
+		if ok := pool.AppendCertsFromPEM(b); !ok {
+			return fmt.Errorf("no certificate found in %s", caCert)
+		}
+
+		r.tlsRootCAs = pool
+	}
+
+	return nil
+}
+
+func (c *client) setTLSStats(state tls.ConnectionState) {
+	c.stats.TLSVersion = state.Version
+	c.stats.TLSCipherSuite = state.CipherSuite
+	c.stats.TLSResumed = uint8(boolToInt(state.DidResume))
+	c.stats.TLSProto = state.NegotiatedProtocol
+	c.stats.TLSVersionName = tlsVersions[state.Version]
+	c.stats.TLSCipherSuiteName = ""
+	c.stats.TLSCertExpiry = 0
+	c.stats.TLSCertIssuer = ""
+	c.stats.TLSCertSANs = ""
+
+	if state.CipherSuite != 0 {
+		c.stats.TLSCipherSuiteName = tls.CipherSuiteName(state.CipherSuite)
+	}
+
+	if len(state.PeerCertificates) > 0 {
+		cert := state.PeerCertificates[0]
+		sans := append([]string{}, cert.DNSNames...)
+		for _, ip := range cert.IPAddresses {
+			sans = append(sans, ip.String())
+		}
+
+		c.stats.TLSCertExpiry = cert.NotAfter.Unix()
+		c.stats.TLSCertIssuer = cert.Issuer.String()
+		c.stats.TLSCertSANs = strings.Join(sans, ",")
+	}
+}
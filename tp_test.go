@@ -1,23 +1,46 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/binary"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math/big"
 	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"os/exec"
 	"reflect"
+	"strconv"
+	"strings"
 	"sync"
+	"syscall"
 	"testing"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/quic-go/quic-go/http3"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"golang.org/x/sys/unix"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes/fake"
@@ -168,21 +191,21 @@ func TestCli(t *testing.T) {
 }
 
 func TestPrometheus(t *testing.T) {
-	c := &client{}
+	c := &client{req: &request{}}
 	c.prometheus(context.Background())
 
 	v := reflect.ValueOf(&c.stats).Elem()
 	for i := 0; i < v.NumField(); i++ {
 		f := v.Type().Field(i)
 
-		if f.Tag.Get("unexported") == "true" {
+		if !c.isExported(f) {
 			continue
 		}
 
 		req := prometheus.NewCounter(prometheus.CounterOpts{
 			Name:        "tp_" + f.Tag.Get("name"),
 			Help:        f.Tag.Get("help"),
-			ConstLabels: prometheus.Labels{"target": c.target},
+			ConstLabels: c.labels(context.Background()),
 		})
 
 		if err := prometheus.Register(req); err != nil {
@@ -190,6 +213,36 @@ func TestPrometheus(t *testing.T) {
 			assert.True(t, ok)
 		}
 	}
+
+	// a target in a network namespace has the same label names
+	c2 := newClient(&request{netns: "blue", soInterface: "eth0", soMark: 1}, "127.0.0.1:80")
+	f, _ := v.Type().FieldByName("Rtt")
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name:        "tp_" + f.Tag.Get("name"),
+		Help:        f.Tag.Get("help"),
+		ConstLabels: c2.labels(context.Background()),
+	})
+	assert.NoError(t, prometheus.Register(gauge))
+	prometheus.Unregister(gauge)
+
+	// the probe specific metrics are only exported for their targets
+	for _, tc := range []struct {
+		field  string
+		req    *request
+		target string
+	}{
+		{"ProtoCheckSuccess", &request{}, "redis://127.0.0.1:6379"},
+		{"GRPCHealthStatus", &request{}, "grpc://127.0.0.1:50051/svc"},
+		{"WSPingAvg", &request{}, "ws://127.0.0.1/"},
+		{"QUICRTT", &request{http3: true}, "https://127.0.0.1/"},
+		{"PMTUBlackhole", &request{pmtu: true}, "127.0.0.1:7"},
+		{"BulkBytes", &request{bulkSize: 1}, "http://127.0.0.1/"},
+		{"TFOSynData", &request{soTCPFastOpen: true}, "127.0.0.1:80"},
+	} {
+		f, _ := v.Type().FieldByName(tc.field)
+		assert.True(t, newClient(tc.req, tc.target).isExported(f), tc.field)
+		assert.False(t, newClient(&request{}, "http://127.0.0.1/").isExported(f), tc.field)
+	}
 }
 
 func TestServerName(t *testing.T) {
@@ -205,14 +258,71 @@ func TestServerName(t *testing.T) {
 }
 
 func TestGetSrcAddr(t *testing.T) {
-	addr := getSrcAddr("")
+	addr, err := getSrcAddr("", true)
+	assert.NoError(t, err)
 	assert.Nil(t, addr)
 
-	addr = getSrcAddr("192.168.1.1")
+	addr, err = getSrcAddr("192.168.1.1", true)
+	assert.NoError(t, err)
 	assert.Equal(t, &net.TCPAddr{
 		IP:   net.ParseIP("192.168.1.1"),
 		Port: 0, Zone: "",
 	}, addr)
+
+	addr, err = getSrcAddr("lo", true)
+	assert.NoError(t, err)
+	assert.Equal(t, "127.0.0.1:0", addr.String())
+
+	_, err = getSrcAddr("notfound0", true)
+	assert.Error(t, err)
+}
+
+func TestBindToDevice(t *testing.T) {
+	ctx := context.Background()
+	addr := fakeServer(t, func(conn net.Conn) {})
+
+	labels := newClient(&request{soInterface: "lo", soMark: 100}, addr).labels(ctx)
+	assert.Equal(t, "lo", labels["interface"])
+	assert.Equal(t, "100", labels["fwmark"])
+
+	// the connection doesn't fall back to the default route
+	c := newClient(&request{timeout: time.Second, soInterface: "nosuchdev0"}, addr)
+	assert.Error(t, c.connect(ctx))
+	assert.Equal(t, int64(1), c.TCPConnectError)
+
+	if !hasCapability(unix.CAP_NET_ADMIN) {
+		t.Skip("setting SO_MARK needs CAP_NET_ADMIN")
+	}
+
+	r := &request{timeout: time.Second, soInterface: "lo", soMark: 100, srcAddr: "lo"}
+	c = newClient(r, addr)
+	assert.NoError(t, c.connect(ctx))
+	defer c.close()
+
+	rawConn, err := c.conn.(*net.TCPConn).SyscallConn()
+	assert.NoError(t, err)
+	rawConn.Control(func(fd uintptr) {
+		mark, err := syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_MARK)
+		assert.NoError(t, err)
+		assert.Equal(t, 100, mark)
+	})
+}
+
+// hasCapability reports whether the process has the effective capability
+func hasCapability(capability uint) bool {
+	b, err := ioutil.ReadFile("/proc/self/status")
+	if err != nil {
+		return false
+	}
+
+	for _, line := range strings.Split(string(b), "\n") {
+		if v := strings.TrimPrefix(line, "CapEff:"); v != line {
+			caps, err := strconv.ParseUint(strings.TrimSpace(v), 16, 64)
+			return err == nil && caps&(1<<capability) != 0
+		}
+	}
+
+	return false
 }
 
 func TestPrintText(t *testing.T) {
@@ -343,6 +453,9 @@ func TestGetConfig(t *testing.T) {
   targets:
     - addr: https://www.google.com
       interval: 10s
+      netns: blue
+      interface: eth1
+      fwmark: 100
       labels:
         pop: bur`
 
@@ -353,6 +466,9 @@ func TestGetConfig(t *testing.T) {
 	assert.Equal(t, "https://www.google.com", cfg.Targets[0].Addr)
 	assert.Equal(t, "10s", cfg.Targets[0].Interval)
 	assert.Equal(t, map[string]string{"pop": "bur"}, cfg.Targets[0].Labels)
+	assert.Equal(t, "blue", cfg.Targets[0].Netns)
+	assert.Equal(t, "eth1", cfg.Targets[0].Interface)
+	assert.Equal(t, 100, cfg.Targets[0].Fwmark)
 
 	_, err = getConfig("notfound")
 	assert.NotNil(t, err)
@@ -461,3 +577,1541 @@ func TestCheckUpdate(t *testing.T) {
 	assert.Equal(t, "", newVersion)
 
 }
+
+func TestHTTPRequest(t *testing.T) {
+	ctx := context.Background()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		if r.Method != http.MethodPost || r.Host != "myhost" ||
+			r.Header.Get("Authorization") != "Bearer token" || string(b) != `{"key":"value"}` {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer ts.Close()
+
+	r := &request{timeout: time.Second * 2}
+	cfg := &httpConfig{Method: "post", Body: `{"key":"value"}`, ExpectStatus: "200-204"}
+	err := r.setHTTP(cfg, []string{"Authorization: Bearer token", "Host: myhost"})
+	assert.NoError(t, err)
+
+	c := newClient(r, ts.URL)
+	assert.NoError(t, c.connect(ctx))
+	assert.NoError(t, c.httpGet())
+	assert.Equal(t, http.StatusCreated, c.HTTPStatusCode)
+	assert.Equal(t, int64(0), c.HTTPStatusMismatch)
+	c.close()
+
+	r.httpMethod = "GET"
+	c = newClient(r, ts.URL)
+	assert.NoError(t, c.connect(ctx))
+	assert.Error(t, c.httpGet())
+	assert.Equal(t, http.StatusBadRequest, c.HTTPStatusCode)
+	assert.Equal(t, int64(1), c.HTTPStatusMismatch)
+	c.close()
+
+	s, err := parseHTTPStatus("200, 300-399")
+	assert.NoError(t, err)
+	assert.True(t, s.match(200))
+	assert.True(t, s.match(302))
+	assert.False(t, s.match(404))
+
+	_, err = parseHTTPStatus("2xx")
+	assert.Error(t, err)
+	_, err = parseHTTPStatus("500-400")
+	assert.Error(t, err)
+	_, err = parseHTTPHeaders([]string{"wrongheader"})
+	assert.Error(t, err)
+}
+
+func TestTargetRequest(t *testing.T) {
+	cfgFile, err := ioutil.TempFile(t.TempDir(), "config.yml")
+	assert.Equal(t, nil, err)
+
+	content := `
+  targets:
+    - addr: https://www.google.com
+      http:
+        method: post
+        headers:
+          Authorization: Bearer token
+        body: hello
+        expect_status: 200-299`
+
+	cfgFile.Write([]byte(content))
+	cfg, err := getConfig(cfgFile.Name())
+	assert.Equal(t, nil, err)
+
+	req := &request{count: 1}
+	r, err := cfg.Targets[0].request(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, r.count)
+	assert.Equal(t, "POST", r.httpMethod)
+	assert.Equal(t, "Bearer token", r.httpHeaders.Get("Authorization"))
+	assert.Equal(t, []byte("hello"), r.httpBody)
+	assert.True(t, r.httpStatus.match(204))
+	assert.Equal(t, "", req.httpMethod)
+
+	cfg.Targets[0].HTTP.BodyFile = "notfound"
+	_, err = cfg.Targets[0].request(req)
+	assert.Error(t, err)
+}
+
+func TestHTTPBodyCheck(t *testing.T) {
+	ctx := context.Background()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"status":"ok","data":{"items":[{"id":1},{"id":2}]}}`)
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		cfg    httpConfig
+		failed int64
+	}{
+		{httpConfig{BodyContains: `"status":"ok"`}, 0},
+		{httpConfig{BodyContains: "maintenance"}, 1},
+		{httpConfig{BodyRegex: `"id":\d+`}, 0},
+		{httpConfig{BodyRegex: `"id":"\w+"`}, 1},
+		{httpConfig{BodyJSON: "status=ok"}, 0},
+		{httpConfig{BodyJSON: "$.data.items[1].id=2"}, 0},
+		{httpConfig{BodyJSON: "data.items.0.id=2"}, 1},
+		{httpConfig{BodyJSON: "data.items[5].id=1"}, 1},
+	}
+
+	for _, test := range tests {
+		r := &request{timeout: time.Second * 2}
+		assert.NoError(t, r.setHTTP(&test.cfg, nil))
+
+		c := newClient(r, ts.URL)
+		assert.NoError(t, c.connect(ctx))
+		err := c.httpGet()
+		assert.Equal(t, test.failed, c.HTTPBodyCheckFailed, test.cfg)
+		assert.Equal(t, test.failed == 1, err != nil)
+		c.close()
+	}
+
+	r := &request{}
+	assert.Error(t, r.setHTTP(&httpConfig{BodyRegex: "("}, nil))
+	assert.Error(t, r.setHTTP(&httpConfig{BodyJSON: "status"}, nil))
+	assert.Error(t, r.setHTTP(&httpConfig{BodyJSON: "$=ok"}, nil))
+
+	// a target overrides only its given checks
+	r = &request{}
+	assert.NoError(t, r.setHTTP(&httpConfig{BodyContains: "TCPProbe", BodyJSON: "status=ok"}, nil))
+	assert.NoError(t, r.setHTTP(&httpConfig{BodyRegex: "^Hello"}, nil))
+	assert.Equal(t, "TCPProbe", r.httpBodyContains)
+	assert.NotNil(t, r.httpBodyJSON)
+	assert.NotNil(t, r.httpBodyRegex)
+
+	pbs := stats2pbStruct(&stats{HTTPBodyCheckFailed: 3})
+	assert.Equal(t, 3.0, pbs.Fields["HTTPBodyCheckFailed"].GetNumberValue())
+}
+
+func TestHTTPRedirect(t *testing.T) {
+	ctx := context.Background()
+	tsTLS := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "Hello, TCPProbe")
+	}))
+	defer tsTLS.Close()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/first":
+			http.Redirect(w, r, "/second", http.StatusFound)
+		case "/second":
+			http.Redirect(w, r, tsTLS.URL+"/final", http.StatusMovedPermanently)
+		}
+	}))
+	defer ts.Close()
+
+	r := &request{timeout: time.Second * 2, insecure: true}
+	c := newClient(r, ts.URL+"/first")
+	assert.NoError(t, c.connect(ctx))
+	assert.Error(t, c.httpGet())
+	assert.Len(t, c.hops, 0)
+	c.close()
+
+	r.followRedirects = 2
+	c = newClient(r, ts.URL+"/first")
+	assert.NoError(t, c.connect(ctx))
+	assert.NoError(t, c.httpGet())
+	assert.NoError(t, c.getTCPInfo())
+	assert.Equal(t, int64(2), c.HTTPRedirects)
+	assert.Equal(t, 200, c.HTTPStatusCode)
+	assert.Len(t, c.hops, 3)
+	assert.Equal(t, ts.URL+"/first", c.hops[0].URL)
+	assert.Equal(t, http.StatusFound, c.hops[0].StatusCode)
+	assert.Less(t, int64(0), c.hops[0].TCPConnect)
+	assert.Equal(t, int64(0), c.hops[1].TCPConnect)
+	assert.Equal(t, tsTLS.URL+"/final", c.hops[2].URL)
+	assert.Less(t, int64(0), c.hops[2].TCPConnect)
+	assert.Less(t, int64(0), c.hops[2].TLSHandshake)
+	assert.Less(t, int64(0), c.hops[2].TTFB)
+	assert.Less(t, int64(0), c.TLSHandshake)
+	assert.Equal(t, tsTLS.Listener.Addr().String(), c.addr)
+	assert.Equal(t, uint8(1), c.State)
+	c.close()
+
+	r.followRedirects = 1
+	c = newClient(r, ts.URL+"/first")
+	assert.NoError(t, c.connect(ctx))
+	assert.Error(t, c.httpGet())
+	assert.Equal(t, int64(1), c.HTTPRedirects)
+	c.close()
+}
+
+func TestTLSStats(t *testing.T) {
+	ctx := context.Background()
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "Hello, TCPProbe")
+	}))
+	defer ts.Close()
+
+	r := &request{timeout: time.Second * 2, insecure: true}
+	c := newClient(r, ts.URL)
+	c.prometheus(ctx)
+	defer c.deprometheus(ctx)
+
+	// the second probe resumes the session of the first one
+	for _, resumed := range []uint8{0, 1} {
+		assert.NoError(t, c.connect(ctx))
+		assert.NoError(t, c.httpGet())
+		c.close()
+
+		assert.Equal(t, resumed, c.TLSResumed)
+	}
+
+	assert.Equal(t, uint16(tls.VersionTLS13), c.TLSVersion)
+	assert.Equal(t, "TLS 1.3", c.TLSVersionName)
+	assert.NotEqual(t, "", c.TLSCipherSuiteName)
+	assert.Equal(t, "http/1.1", c.TLSProto)
+	assert.Contains(t, c.TLSCertSANs, "127.0.0.1")
+	assert.Contains(t, c.TLSCertIssuer, "Acme Co")
+	assert.Less(t, time.Now().Unix(), c.TLSCertExpiry)
+
+	mfs, err := prometheus.DefaultGatherer.Gather()
+	assert.NoError(t, err)
+
+	found := false
+	for _, mf := range mfs {
+		if mf.GetName() != "tp_tls_info" {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			labels := map[string]string{}
+			for _, l := range m.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+			if labels["target"] == ts.URL {
+				found = true
+				assert.Equal(t, "TLS 1.3", labels["version"])
+				assert.Equal(t, "http/1.1", labels["alpn"])
+			}
+		}
+	}
+	assert.True(t, found)
+
+	// the raw TLS probe doesn't offer any application protocol
+	c = newClient(r, "tls://"+ts.Listener.Addr().String())
+	assert.NoError(t, c.connect(ctx))
+	assert.NoError(t, c.exchange())
+	assert.Equal(t, "TLS 1.3", c.TLSVersionName)
+	assert.Equal(t, "", c.TLSProto)
+	c.close()
+}
+
+func TestMutualTLS(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	genCert := func(name string, isCA bool, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+		key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		tmpl := &x509.Certificate{
+			SerialNumber:          big.NewInt(time.Now().UnixNano()),
+			Subject:               pkix.Name{CommonName: name},
+			NotBefore:             time.Now().Add(-time.Hour),
+			NotAfter:              time.Now().Add(time.Hour),
+			IsCA:                  isCA,
+			BasicConstraintsValid: true,
+			KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+			ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+			IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		}
+		if parent == nil {
+			parent, parentKey = tmpl, key
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+		assert.NoError(t, err)
+		cert, _ := x509.ParseCertificate(der)
+		keyDer, _ := x509.MarshalECPrivateKey(key)
+
+		ioutil.WriteFile(dir+"/"+name+".crt", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+		ioutil.WriteFile(dir+"/"+name+".key", pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+
+		return cert, key
+	}
+
+	ca, caKey := genCert("ca", true, nil, nil)
+	genCert("server", false, ca, caKey)
+	genCert("client", false, ca, caKey)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	serverCert, err := tls.LoadX509KeyPair(dir+"/server.crt", dir+"/server.key")
+	assert.NoError(t, err)
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "Hello, TCPProbe")
+	}))
+	ts.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	}
+	ts.StartTLS()
+	defer ts.Close()
+
+	// without client certificate
+	r := &request{timeout: time.Second * 2}
+	assert.NoError(t, r.setTLS("", "", dir+"/ca.crt"))
+	c := newClient(r, ts.URL)
+	assert.NoError(t, c.connect(ctx))
+	assert.Error(t, c.httpGet())
+	c.close()
+
+	assert.NoError(t, r.setTLS(dir+"/client.crt", dir+"/client.key", ""))
+	c = newClient(r, ts.URL)
+	assert.NoError(t, c.connect(ctx))
+	assert.NoError(t, c.httpGet())
+	assert.Equal(t, 200, c.HTTPStatusCode)
+	assert.Equal(t, int64(0), c.TLSHandshakeError)
+	c.close()
+
+	// unknown authority
+	c = newClient(&request{timeout: time.Second * 2}, ts.URL)
+	assert.NoError(t, c.connect(ctx))
+	assert.Error(t, c.httpGet())
+	assert.Equal(t, int64(1), c.TLSHandshakeError)
+	c.close()
+
+	assert.Error(t, r.setTLS("notfound", "", ""))
+	assert.Error(t, r.setTLS("", "", dir+"/ca.key"))
+}
+
+func fakeServer(t *testing.T, handler func(conn net.Conn)) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	fakeServe(t, ln, handler)
+
+	return ln.Addr().String()
+}
+
+// netnsServer creates a network namespace with the loopback up, runs
+// the commands inside it and serves the handler on its loopback, the
+// test is skipped if the namespace can't be set up
+func netnsServer(t *testing.T, cmds [][]string, handler func(conn net.Conn)) (string, string) {
+	if os.Geteuid() != 0 {
+		t.Skip("creating a network namespace needs root")
+	}
+
+	name := fmt.Sprintf("tp%s%d", strings.ToLower(strings.TrimPrefix(t.Name(), "Test")), os.Getpid())
+	if out, err := exec.Command("ip", "netns", "add", name).CombinedOutput(); err != nil {
+		t.Skip(string(out), err)
+	}
+	t.Cleanup(func() { exec.Command("ip", "netns", "del", name).Run() })
+
+	for _, args := range append([][]string{{"ip", "link", "set", "lo", "up"}}, cmds...) {
+		out, err := exec.Command("ip", append([]string{"netns", "exec", name}, args...)...).CombinedOutput()
+		if err != nil {
+			t.Skip(string(out), err)
+		}
+	}
+
+	var ln net.Listener
+	err := inNetns(name, func() error {
+		var err error
+		ln, err = net.Listen("tcp", "127.0.0.1:0")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fakeServe(t, ln, handler)
+
+	return name, ln.Addr().String()
+}
+
+// fakeServe runs the handler for each connection until the test ends
+func fakeServe(t *testing.T, ln net.Listener, handler func(conn net.Conn)) {
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				handler(conn)
+			}()
+		}
+	}()
+}
+
+func TestStartTLS(t *testing.T) {
+	ctx := context.Background()
+	ts := httptest.NewTLSServer(nil)
+	defer ts.Close()
+
+	upgrade := func(conn net.Conn) {
+		tls.Server(conn, ts.TLS).Handshake()
+	}
+
+	servers := map[string]func(conn net.Conn){
+		"smtp": func(conn net.Conn) {
+			r := bufio.NewReader(conn)
+			fmt.Fprint(conn, "220-smtp.tcpprobe\r\n220 ESMTP\r\n")
+			r.ReadString('\n')
+			fmt.Fprint(conn, "250-smtp.tcpprobe\r\n250 STARTTLS\r\n")
+			r.ReadString('\n')
+			fmt.Fprint(conn, "220 ready\r\n")
+			upgrade(conn)
+		},
+		"imap": func(conn net.Conn) {
+			r := bufio.NewReader(conn)
+			fmt.Fprint(conn, "* OK IMAP4rev1\r\n")
+			r.ReadString('\n')
+			fmt.Fprint(conn, "* CAPABILITY IMAP4rev1\r\na001 OK begin TLS\r\n")
+			upgrade(conn)
+		},
+		"pop3": func(conn net.Conn) {
+			r := bufio.NewReader(conn)
+			fmt.Fprint(conn, "+OK POP3\r\n")
+			r.ReadString('\n')
+			fmt.Fprint(conn, "+OK begin TLS\r\n")
+			upgrade(conn)
+		},
+		"ftp": func(conn net.Conn) {
+			r := bufio.NewReader(conn)
+			fmt.Fprint(conn, "220 FTP\r\n")
+			r.ReadString('\n')
+			fmt.Fprint(conn, "234 AUTH TLS OK\r\n")
+			upgrade(conn)
+		},
+		"postgres": func(conn net.Conn) {
+			io.ReadFull(conn, make([]byte, 8))
+			conn.Write([]byte("S"))
+			upgrade(conn)
+		},
+	}
+
+	for proto, handler := range servers {
+		addr := fakeServer(t, handler)
+
+		r := &request{timeout: time.Second * 2, insecure: true}
+		target := proto + "://" + addr
+		if proto == "postgres" {
+			r.starttls = proto
+			target = addr
+		}
+
+		c := newClient(r, target)
+		assert.NoError(t, c.connect(ctx))
+		assert.NoError(t, c.exchange(), proto)
+		assert.NoError(t, c.getTCPInfo())
+		assert.Less(t, int64(0), c.STARTTLSNegotiation, proto)
+		assert.Less(t, int64(0), c.TLSHandshake, proto)
+		assert.Equal(t, "TLS 1.3", c.TLSVersionName)
+		c.close()
+	}
+
+	// server rejects STARTTLS
+	addr := fakeServer(t, func(conn net.Conn) {
+		fmt.Fprint(conn, "554 no service\r\n")
+	})
+	c := newClient(&request{timeout: time.Second * 2}, "smtp://"+addr)
+	assert.NoError(t, c.connect(ctx))
+	assert.Error(t, c.exchange())
+	c.close()
+
+	c = newClient(&request{starttls: "unknown"}, "127.0.0.1:25")
+	assert.Error(t, c.exchange())
+
+	c = newClient(&request{}, "imap://127.0.0.1")
+	_, port, _ := c.getHostPort()
+	assert.Equal(t, "143", port)
+}
+
+func TestTLSProbe(t *testing.T) {
+	ctx := context.Background()
+	ts := httptest.NewTLSServer(nil)
+	defer ts.Close()
+
+	addr := fakeServer(t, func(conn net.Conn) {
+		tlsConn := tls.Server(conn, ts.TLS)
+		tlsConn.Handshake()
+		io.Copy(ioutil.Discard, tlsConn)
+	})
+
+	r := &request{timeout: time.Second * 2, insecure: true}
+	c := newClient(r, "tls://"+addr)
+	assert.True(t, c.isTLS())
+	assert.NoError(t, c.connect(ctx))
+	assert.NoError(t, c.exchange())
+	assert.NoError(t, c.getTCPInfo())
+	assert.Less(t, int64(0), c.TLSHandshake)
+	assert.Equal(t, "TLS 1.3", c.TLSVersionName)
+	assert.Equal(t, int64(0), c.HTTPRequest)
+	assert.Less(t, uint64(0), c.BytesReceived)
+	c.close()
+
+	// plain TCP service
+	addr = fakeServer(t, func(conn net.Conn) {
+		fmt.Fprint(conn, "SSH-2.0-OpenSSH_8.2\r\n")
+	})
+
+	c = newClient(&request{timeout: time.Second * 2}, "tls://"+addr)
+	assert.NoError(t, c.connect(ctx))
+	assert.Error(t, c.exchange())
+	assert.Equal(t, int64(1), c.TLSHandshakeError)
+	c.close()
+
+	c = newClient(&request{}, "ldaps://127.0.0.1")
+	assert.True(t, c.isTLS())
+	_, port, _ := c.getHostPort()
+	assert.Equal(t, "636", port)
+}
+
+func TestPayload(t *testing.T) {
+	ctx := context.Background()
+	addr := fakeServer(t, func(conn net.Conn) {
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch line {
+			case "PING\r\n":
+				fmt.Fprint(conn, "+PONG\r\n")
+			case "version\r\n":
+				fmt.Fprint(conn, "VERSION 1.6.9\r\n")
+			default:
+				fmt.Fprint(conn, "-ERR\r\n")
+			}
+		}
+	})
+
+	tests := []struct {
+		cfg    payloadConfig
+		failed int64
+	}{
+		{payloadConfig{Send: `PING\r\n`, ExpectDelim: `\r\n`}, 0},
+		{payloadConfig{SendHex: "50 49 4e 47 0d 0a", ExpectBytes: 7}, 0},
+		{payloadConfig{Send: `version\r\n`, ExpectRegex: `^VERSION \d+\.\d+`}, 0},
+		{payloadConfig{Send: `version\r\n`, ExpectDelim: `\r\n`, ExpectRegex: `^\+PONG`}, 1},
+		{payloadConfig{Send: `PING\r\n`, ExpectBytes: 100}, 1},
+	}
+
+	for _, test := range tests {
+		r := &request{timeout: time.Millisecond * 500}
+		assert.NoError(t, r.setPayload(&test.cfg))
+
+		c := newClient(r, addr)
+		assert.NoError(t, c.connect(ctx))
+		err := c.exchange()
+		assert.Equal(t, test.failed == 1, err != nil, test.cfg)
+		assert.Equal(t, test.failed, c.PayloadMatchFailed)
+		assert.Less(t, int64(0), c.PayloadRTT)
+		if test.failed == 0 {
+			assert.LessOrEqual(t, c.PayloadRTT, c.PayloadComplete)
+		}
+		c.close()
+	}
+
+	// banner
+	addr = fakeServer(t, func(conn net.Conn) {
+		fmt.Fprint(conn, "SSH-2.0-OpenSSH_8.2\r\n")
+	})
+
+	r := &request{timeout: time.Second}
+	assert.NoError(t, r.setPayload(&payloadConfig{ExpectRegex: `^SSH-2\.0`}))
+	c := newClient(r, "tcp://"+addr)
+	assert.NoError(t, c.connect(ctx))
+	assert.NoError(t, c.exchange())
+	assert.Less(t, int64(0), c.PayloadComplete)
+	c.close()
+
+	assert.Error(t, r.setPayload(&payloadConfig{SendHex: "zz"}))
+	assert.Error(t, r.setPayload(&payloadConfig{ExpectRegex: "("}))
+	assert.Error(t, r.setPayload(&payloadConfig{Send: `\x`}))
+
+	b, err := unescape(`say "hi"\n`)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("say \"hi\"\n"), b)
+}
+
+func TestProtoCheck(t *testing.T) {
+	ctx := context.Background()
+
+	redis := fakeServer(t, func(conn net.Conn) {
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch strings.TrimSpace(line) {
+			case "PING":
+				fmt.Fprint(conn, "+PONG\r\n")
+			case "secret":
+				fmt.Fprint(conn, "+OK\r\n")
+			case "wrong":
+				fmt.Fprint(conn, "-WRONGPASS invalid password\r\n")
+			}
+		}
+	})
+
+	mysql := fakeServer(t, func(conn net.Conn) {
+		payload := append([]byte{10}, []byte("8.0.22\x00rest")...)
+		conn.Write(append([]byte{byte(len(payload)), 0, 0, 0}, payload...))
+	})
+
+	postgres := fakeServer(t, func(conn net.Conn) {
+		io.ReadFull(conn, make([]byte, 8))
+		conn.Write([]byte("N"))
+		header := make([]byte, 4)
+		io.ReadFull(conn, header)
+		io.ReadFull(conn, make([]byte, binary.BigEndian.Uint32(header)-4))
+		conn.Write([]byte{'R', 0, 0, 0, 8, 0, 0, 0, 5})
+	})
+
+	ssh := fakeServer(t, func(conn net.Conn) {
+		fmt.Fprint(conn, "SSH-2.0-OpenSSH_8.2p1 Ubuntu-4\r\n")
+	})
+
+	tests := []struct {
+		target  string
+		version string
+		success uint8
+	}{
+		{"redis://" + redis, "", 1},
+		{"redis://:secret@" + redis, "", 1},
+		{"redis://:wrong@" + redis, "", 0},
+		{"mysql://" + mysql, "8.0.22", 1},
+		{"postgres://" + postgres, "", 1},
+		{"ssh://" + ssh, "SSH-2.0-OpenSSH_8.2p1 Ubuntu-4", 1},
+		{"mysql://" + ssh, "", 0},
+	}
+
+	for _, test := range tests {
+		c := newClient(&request{timeout: time.Second}, test.target)
+		assert.NoError(t, c.connect(ctx))
+		err := c.exchange()
+		assert.Equal(t, test.success == 0, err != nil, test.target)
+		assert.Equal(t, test.success, c.ProtoCheckSuccess, test.target)
+		assert.Equal(t, test.version, c.ProtoServerVersion)
+		if test.success == 1 {
+			assert.Less(t, int64(0), c.ProtoCheck)
+		}
+		c.close()
+	}
+
+	c := newClient(&request{}, "redis:6379")
+	assert.Nil(t, c.getChecker())
+}
+
+func TestGRPCHealthCheck(t *testing.T) {
+	ctx := context.Background()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	hs := health.NewServer()
+	hs.SetServingStatus("up", healthpb.HealthCheckResponse_SERVING)
+	hs.SetServingStatus("down", healthpb.HealthCheckResponse_NOT_SERVING)
+
+	s := grpc.NewServer()
+	healthpb.RegisterHealthServer(s, hs)
+	go s.Serve(l)
+	defer s.Stop()
+
+	tests := []struct {
+		service string
+		status  int32
+		failed  int64
+	}{
+		{"", 1, 0},
+		{"up", 1, 0},
+		{"down", 2, 0},
+		{"unknown", 0, 1},
+	}
+
+	for _, test := range tests {
+		c := newClient(&request{timeout: time.Second * 2}, "grpc://"+l.Addr().String()+"/"+test.service)
+		assert.True(t, c.isGRPC())
+		assert.NoError(t, c.connect(ctx))
+		err := c.exchange()
+		assert.Equal(t, test.status != 1, err != nil)
+		assert.Equal(t, test.status, c.GRPCHealthStatus, test.service)
+		assert.Equal(t, test.failed, c.GRPCHealthError)
+		if test.failed == 0 {
+			assert.Less(t, int64(0), c.GRPCHealthCheck)
+		}
+		assert.NoError(t, c.getTCPInfo())
+		assert.Less(t, uint64(0), c.BytesReceived)
+		c.close()
+	}
+
+	// not a gRPC server
+	ts := httptest.NewServer(nil)
+	defer ts.Close()
+
+	c := newClient(&request{timeout: time.Millisecond * 500}, "grpc://"+ts.Listener.Addr().String())
+	assert.NoError(t, c.connect(ctx))
+	assert.Error(t, c.exchange())
+	assert.Equal(t, int64(1), c.GRPCHealthError)
+	c.close()
+}
+
+// wsEchoHandler upgrades the connection and answers the pings
+func wsEchoHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Upgrade") != "websocket" {
+		http.NotFound(w, r)
+		return
+	}
+
+	conn, rw, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	h := sha1.Sum([]byte(r.Header.Get("Sec-WebSocket-Key") + wsGUID))
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n",
+		base64.StdEncoding.EncodeToString(h[:]))
+	rw.Flush()
+
+	for {
+		opcode, payload, err := wsReadFrame(rw.Reader)
+		if err != nil || opcode == wsOpClose {
+			return
+		}
+
+		if opcode == wsOpPing {
+			conn.Write(append([]byte{0x80 | wsOpPong, byte(len(payload))}, payload...))
+		}
+	}
+}
+
+func TestWebSocket(t *testing.T) {
+	ctx := context.Background()
+	handler := http.HandlerFunc(wsEchoHandler)
+
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+	tsTLS := httptest.NewTLSServer(handler)
+	defer tsTLS.Close()
+
+	for _, target := range []string{
+		"ws://" + ts.Listener.Addr().String() + "/",
+		"wss://" + tsTLS.Listener.Addr().String() + "/",
+	} {
+		c := newClient(&request{timeout: time.Second * 2, insecure: true, wsPings: 3}, target)
+		assert.True(t, c.isWebSocket())
+		assert.NoError(t, c.connect(ctx))
+		assert.NoError(t, c.exchange())
+		assert.NoError(t, c.getTCPInfo())
+		assert.Equal(t, http.StatusSwitchingProtocols, c.HTTPStatusCode)
+		assert.Less(t, int64(0), c.WSUpgrade)
+		assert.Less(t, int64(0), c.WSPingMin)
+		assert.LessOrEqual(t, c.WSPingMin, c.WSPingAvg)
+		assert.LessOrEqual(t, c.WSPingAvg, c.WSPingMax)
+		assert.Less(t, uint32(3), c.SegsOut)
+		c.close()
+	}
+
+	// not a WebSocket endpoint
+	ts = httptest.NewServer(http.NotFoundHandler())
+	defer ts.Close()
+
+	c := newClient(&request{timeout: time.Second, wsPings: 1}, "ws://"+ts.Listener.Addr().String())
+	assert.NoError(t, c.connect(ctx))
+	assert.Error(t, c.exchange())
+	assert.Equal(t, int64(1), c.WSError)
+	assert.Equal(t, http.StatusNotFound, c.HTTPStatusCode)
+	c.close()
+}
+
+func TestHTTP2(t *testing.T) {
+	ctx := context.Background()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, r.Proto)
+	})
+
+	ts := httptest.NewServer(h2c.NewHandler(handler, &http2.Server{}))
+	defer ts.Close()
+
+	r := &request{timeout: time.Second * 2, http2: true, httpBodyContains: "HTTP/2.0"}
+	c := newClient(r, ts.URL)
+	assert.NoError(t, c.connect(ctx))
+	assert.NoError(t, c.httpGet())
+	assert.Equal(t, "HTTP/2.0", c.HTTPProto)
+	assert.Equal(t, 200, c.HTTPStatusCode)
+	assert.Less(t, int64(0), c.HTTP2Settings)
+	assert.Less(t, int64(0), c.HTTP2Ping)
+	c.close()
+
+	r = &request{timeout: time.Second * 2}
+	c = newClient(r, ts.URL)
+	assert.NoError(t, c.connect(ctx))
+	assert.NoError(t, c.httpGet())
+	assert.Equal(t, "HTTP/1.1", c.HTTPProto)
+	assert.Equal(t, int64(0), c.HTTP2Ping)
+	c.close()
+
+	tsTLS := httptest.NewUnstartedServer(handler)
+	tsTLS.EnableHTTP2 = true
+	tsTLS.StartTLS()
+	defer tsTLS.Close()
+
+	r = &request{timeout: time.Second * 2, insecure: true, http2: true}
+	c = newClient(r, tsTLS.URL)
+	assert.NoError(t, c.connect(ctx))
+	assert.NoError(t, c.httpGet())
+	assert.Equal(t, "HTTP/2.0", c.HTTPProto)
+	assert.Equal(t, "h2", c.TLSProto)
+	assert.Less(t, int64(0), c.HTTP2Settings)
+	assert.Less(t, int64(0), c.HTTP2Ping)
+	c.close()
+
+	// the server doesn't negotiate HTTP/2
+	tsTLS1 := httptest.NewTLSServer(handler)
+	defer tsTLS1.Close()
+
+	c = newClient(r, tsTLS1.URL)
+	assert.NoError(t, c.connect(ctx))
+	assert.NoError(t, c.httpGet())
+	assert.Equal(t, "HTTP/1.1", c.HTTPProto)
+	assert.Equal(t, "http/1.1", c.TLSProto)
+	assert.Equal(t, 200, c.HTTPStatusCode)
+	assert.Equal(t, int64(0), c.HTTP2Ping)
+	c.close()
+}
+
+func TestHTTP3(t *testing.T) {
+	ctx := context.Background()
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	udpConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer udpConn.Close()
+
+	server := &http3.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintln(w, r.Proto)
+		}),
+		TLSConfig: http3.ConfigureTLSConfig(ts.TLS.Clone()),
+	}
+	go server.Serve(udpConn)
+	defer server.Close()
+
+	target := "https://" + udpConn.LocalAddr().String()
+	r := &request{timeout: time.Second * 2, insecure: true, http3: true, httpBodyContains: "HTTP/3.0"}
+	c := newClient(r, target)
+	assert.NoError(t, c.connect(ctx))
+	assert.NoError(t, c.exchange())
+	assert.NoError(t, c.getTCPInfo())
+	assert.Equal(t, "HTTP/3.0", c.HTTPProto)
+	assert.Equal(t, 200, c.HTTPStatusCode)
+	assert.Equal(t, "h3", c.TLSProto)
+	assert.Less(t, int64(0), c.QUICHandshake)
+	assert.Less(t, int64(0), c.QUICRTT)
+	assert.Less(t, uint64(0), c.QUICPacketsSent)
+	assert.Equal(t, int64(0), c.TCPConnect)
+	assert.Equal(t, uint32(0), c.Rtt)
+	c.close()
+
+	// the UDP socket has the source interface and the socket options, the TCP options are ignored
+	r2 := &request{timeout: time.Second * 2, insecure: true, http3: true, srcAddr: "lo", soIPTTL: 33,
+		soCongestion: "cubic", soMaxSegSize: 1200, userTimeout: time.Second}
+	c = newClient(r2, target)
+	assert.NoError(t, c.connect(ctx))
+	assert.Equal(t, "127.0.0.1", c.conn.LocalAddr().(*net.UDPAddr).IP.String())
+	rawConn, err := c.conn.(*net.UDPConn).SyscallConn()
+	assert.NoError(t, err)
+	rawConn.Control(func(fd uintptr) {
+		v, _ := syscall.GetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TTL)
+		assert.Equal(t, 33, v)
+	})
+	assert.NoError(t, c.exchange())
+	assert.Equal(t, 200, c.HTTPStatusCode)
+	c.close()
+
+	// nothing is listening on the target
+	udpConn2, _ := net.ListenPacket("udp", "127.0.0.1:0")
+	udpConn2.Close()
+
+	r.timeout = time.Millisecond * 200
+	c = newClient(r, "https://"+udpConn2.LocalAddr().String())
+	assert.NoError(t, c.connect(ctx))
+	assert.Error(t, c.exchange())
+	assert.Equal(t, int64(1), c.QUICHandshakeError)
+	c.close()
+}
+
+func TestHTTPTimings(t *testing.T) {
+	ctx := context.Background()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(ioutil.Discard, r.Body)
+		time.Sleep(50 * time.Millisecond)
+		w.Write(make([]byte, 1<<20))
+	}))
+	defer ts.Close()
+
+	r := &request{timeout: time.Second * 2, httpMethod: http.MethodPost, httpBody: make([]byte, 1<<20)}
+	c := newClient(r, ts.URL)
+	assert.NoError(t, c.connect(ctx))
+	assert.NoError(t, c.httpGet())
+	assert.Less(t, int64(0), c.HTTPWrite)
+	assert.LessOrEqual(t, int64(50000), c.HTTPTTFB)
+	assert.LessOrEqual(t, int64(0), c.HTTPHeaders)
+	assert.Less(t, c.HTTPTTFB, c.HTTPRequest)
+	assert.Equal(t, int64(1<<20), c.HTTPRcvdBytes)
+	assert.Less(t, int64(0), c.HTTPThroughput)
+	c.close()
+}
+
+func TestBulk(t *testing.T) {
+	ctx := context.Background()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			n, _ := io.Copy(ioutil.Discard, r.Body)
+			fmt.Fprint(w, n)
+			return
+		}
+
+		b := make([]byte, 64<<10)
+		for {
+			if _, err := w.Write(b); err != nil {
+				return
+			}
+		}
+	}))
+	defer ts.Close()
+
+	r := &request{timeout: time.Second * 2, bulkSize: 8 << 20, bulkInterval: time.Millisecond}
+	c := newClient(r, ts.URL)
+	assert.NoError(t, c.connect(ctx))
+	assert.NoError(t, c.exchange())
+	assert.NoError(t, c.getTCPInfo())
+	assert.Equal(t, int64(8<<20), c.BulkBytes)
+	assert.Less(t, int64(0), c.BulkDuration)
+	assert.Less(t, int64(0), c.BulkThroughput)
+	assert.Equal(t, 200, c.HTTPStatusCode)
+	c.close()
+
+	r = &request{timeout: time.Second * 2, bulkDuration: 200 * time.Millisecond, bulkInterval: 10 * time.Millisecond, bulkUpload: true}
+	c = newClient(r, ts.URL)
+	assert.NoError(t, c.connect(ctx))
+	assert.NoError(t, c.exchange())
+	assert.Less(t, int64(0), c.BulkBytes)
+	assert.LessOrEqual(t, int64(200000), c.BulkDuration)
+	assert.NotEmpty(t, c.samples)
+	assert.Less(t, uint32(0), c.BulkSndCwndMax)
+	assert.LessOrEqual(t, c.BulkRttMin, c.BulkRttMax)
+	c.close()
+
+	// the HTTP timeout doesn't cut off a transfer longer than it
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < 10; i++ {
+			w.Write(make([]byte, 1024))
+			w.(http.Flusher).Flush()
+			time.Sleep(30 * time.Millisecond)
+		}
+	}))
+	defer slow.Close()
+
+	r = &request{timeout: time.Second, timeoutHTTP: 100 * time.Millisecond, bulkSize: 1 << 20}
+	c = newClient(r, slow.URL)
+	assert.NoError(t, c.connect(ctx))
+	assert.NoError(t, c.exchange())
+	assert.Equal(t, int64(10240), c.BulkBytes)
+	c.close()
+
+	// raw TCP download after a request payload
+	addr := fakeServer(t, func(conn net.Conn) {
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		if line == "download\n" {
+			conn.Write(make([]byte, 1<<20))
+		}
+	})
+
+	r = &request{timeout: time.Second * 2, bulkSize: 4 << 20, bulkInterval: time.Millisecond, payload: []byte("download\n")}
+	c = newClient(r, addr)
+	assert.NoError(t, c.connect(ctx))
+	assert.NoError(t, c.exchange())
+	assert.Equal(t, int64(1<<20), c.BulkBytes)
+	c.close()
+
+	// a stalled peer fails the transfer after the idle timeout
+	addr = fakeServer(t, func(conn net.Conn) {
+		conn.Write(make([]byte, 1024))
+		time.Sleep(time.Second)
+	})
+
+	r = &request{timeout: time.Millisecond * 200, bulkSize: 1 << 20}
+	c = newClient(r, addr)
+	assert.NoError(t, c.connect(ctx))
+	assert.Error(t, c.exchange())
+	assert.Equal(t, int64(1024), c.BulkBytes)
+	assert.Less(t, c.BulkDuration, int64(time.Second/time.Microsecond))
+	c.close()
+}
+
+func TestServe(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s, err := newServer(&serveReq{addr: "127.0.0.1:0", quiet: true})
+	assert.NoError(t, err)
+	go s.serve(ctx)
+
+	conn, err := net.Dial("tcp", s.ln.Addr().String())
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	readLine := func(cmd string) string {
+		fmt.Fprint(conn, cmd)
+		line, err := r.ReadString('\n')
+		assert.NoError(t, err)
+		return strings.TrimSpace(line)
+	}
+
+	assert.Equal(t, "hello tcpprobe", readLine("echo hello tcpprobe\n"))
+	assert.Equal(t, "1024", readLine("upload 1024\n"+strings.Repeat("x", 1024)))
+	assert.Equal(t, "error unknown command: foo", readLine("foo\n"))
+
+	var info map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(readLine("tcpinfo\n")), &info))
+	assert.Equal(t, float64(1), info["State"])
+	assert.Contains(t, info, "Rtt")
+	assert.NotContains(t, info, "HTTPStatusCode")
+
+	fmt.Fprint(conn, "download 4096\n")
+	n, err := io.CopyN(ioutil.Discard, r, 4096)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4096), n)
+
+	// the client's payload and bulk modes against the server
+	req := &request{timeout: time.Second * 2, payload: []byte("echo ping\n"), expectDelim: []byte("\n")}
+	c := newClient(req, s.ln.Addr().String())
+	assert.NoError(t, c.connect(ctx))
+	assert.NoError(t, c.exchange())
+	assert.Less(t, int64(0), c.PayloadComplete)
+	c.close()
+
+	req = &request{timeout: time.Second * 2, payload: []byte("download 0\n"), bulkSize: 1 << 20}
+	c = newClient(req, s.ln.Addr().String())
+	assert.NoError(t, c.connect(ctx))
+	assert.NoError(t, c.exchange())
+	assert.Equal(t, int64(1<<20), c.BulkBytes)
+	c.close()
+
+	// HTTP endpoints
+	hs, err := newServer(&serveReq{addr: "127.0.0.1:0", http: true, quiet: true})
+	assert.NoError(t, err)
+	go hs.serve(ctx)
+
+	url := "http://" + hs.ln.Addr().String()
+	resp, err := http.Get(url + "/download?size=1000")
+	assert.NoError(t, err)
+	b, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Len(t, b, 1000)
+
+	resp, err = http.Post(url+"/upload", "", strings.NewReader("tcpprobe"))
+	assert.NoError(t, err)
+	b, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "8\n", string(b))
+
+	resp, err = http.Get(url + "/tcpinfo")
+	assert.NoError(t, err)
+	info = map[string]interface{}{}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&info))
+	resp.Body.Close()
+	assert.Equal(t, float64(1), info["State"])
+
+	// the unlimited download ends at the max duration
+	hs, err = newServer(&serveReq{addr: "127.0.0.1:0", http: true, quiet: true, maxDuration: 200 * time.Millisecond})
+	assert.NoError(t, err)
+	go hs.serve(ctx)
+
+	start := time.Now()
+	resp, err = (&http.Client{Timeout: 5 * time.Second}).Get("http://" + hs.ln.Addr().String() + "/download")
+	assert.NoError(t, err)
+	n, _ = io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	assert.Less(t, int64(0), n)
+	assert.Less(t, time.Since(start), 2*time.Second)
+}
+
+func TestResponder(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s, err := newServer(&serveReq{addr: "127.0.0.1:0", quiet: true})
+	assert.NoError(t, err)
+	go s.serve(ctx)
+
+	r := &request{timeout: time.Second * 2, responder: true, payload: []byte("echo ping\n"), expectDelim: []byte("\n")}
+	c := newClient(r, s.ln.Addr().String())
+	assert.NoError(t, c.connect(ctx))
+	assert.NoError(t, c.exchange())
+	assert.NoError(t, c.getServerTCPInfo())
+	assert.NoError(t, c.getTCPInfo())
+	assert.NotNil(t, c.stats.server)
+	assert.Equal(t, uint8(1), c.stats.server.State)
+	assert.Less(t, uint64(0), c.stats.server.BytesReceived)
+
+	ch := make(chan prometheus.Metric, 100)
+	collector := c.serverCollector(ctx)
+	collector.Collect(ch)
+	assert.Equal(t, len(collector.descs), len(ch))
+	assert.Contains(t, (<-ch).Desc().String(), "tp_server_tcpinfo_")
+
+	pbs := stats2pbStruct(&c.stats)
+	assert.Contains(t, pbs.Fields, "Server")
+	assert.Contains(t, pbs.Fields["Server"].GetStructValue().Fields, "Rtt")
+	assert.NotContains(t, pbs.Fields["Server"].GetStructValue().Fields, "HTTPStatusCode")
+	c.close()
+
+	// HTTP responder over the probe's connection
+	hs, err := newServer(&serveReq{addr: "127.0.0.1:0", http: true, quiet: true})
+	assert.NoError(t, err)
+	go hs.serve(ctx)
+
+	c = newClient(r, "http://"+hs.ln.Addr().String()+"/download?size=1024")
+	assert.NoError(t, c.connect(ctx))
+	assert.NoError(t, c.exchange())
+	assert.NoError(t, c.getServerTCPInfo())
+	assert.Equal(t, uint8(1), c.stats.server.State)
+	assert.Less(t, uint64(1024), c.stats.server.BytesAcked)
+	c.close()
+
+	// the responder keeps streaming the unlimited download,
+	// the TCP_INFO is fetched over a control connection
+	r = &request{timeout: time.Second * 2, responder: true, payload: []byte("download 0\n"), bulkDuration: time.Millisecond * 200}
+	c = newClient(r, s.ln.Addr().String())
+	assert.NoError(t, c.connect(ctx))
+	assert.NoError(t, c.exchange())
+	assert.NoError(t, c.getServerTCPInfo())
+	assert.Equal(t, int64(0), c.ResponderError)
+	assert.Equal(t, uint8(1), c.stats.server.State)
+	assert.Less(t, uint64(0), c.stats.server.BytesAcked)
+	c.close()
+
+	c = newClient(r, "http://"+hs.ln.Addr().String()+"/download")
+	assert.NoError(t, c.connect(ctx))
+	assert.NoError(t, c.exchange())
+	assert.NoError(t, c.getServerTCPInfo())
+	assert.Equal(t, int64(0), c.ResponderError)
+	assert.Less(t, uint64(0), c.stats.server.BytesAcked)
+	c.close()
+
+	// not a responder
+	addr := fakeServer(t, func(conn net.Conn) {
+		conn.Write([]byte("foo\n"))
+	})
+
+	c = newClient(r, addr)
+	assert.NoError(t, c.connect(ctx))
+	assert.Error(t, c.getServerTCPInfo())
+	assert.Nil(t, c.stats.server)
+	assert.Equal(t, int64(1), c.ResponderError)
+	c.close()
+}
+
+func TestPMTU(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s, err := newServer(&serveReq{addr: "127.0.0.1:0", quiet: true})
+	assert.NoError(t, err)
+	go s.serve(ctx)
+
+	r := &request{timeout: time.Second * 2, responder: true, pmtu: true}
+	c := newClient(r, s.ln.Addr().String())
+	assert.NoError(t, c.connect(ctx))
+	assert.NoError(t, c.exchange())
+	assert.Len(t, c.pmtuSteps, len(pmtuSizes))
+	assert.True(t, c.pmtuSteps[len(pmtuSizes)-1].OK)
+	assert.Equal(t, 1, c.pmtuSteps[len(pmtuSizes)-1].Attempts)
+	assert.Equal(t, int64(9000), c.stats.PMTUMaxSize)
+	assert.Equal(t, uint8(0), c.stats.PMTUBlackhole)
+	assert.Equal(t, uint32(65536), c.stats.PMTUInterfaceMtu)
+	assert.Less(t, uint32(9000), c.stats.PMTUEffective)
+	assert.Less(t, uint32(9000), c.stats.PMTUIPMtu)
+	c.close()
+
+	// each size is sent as a single segment clamped by the MSS
+	for i := 1; i < len(c.pmtuSteps); i++ {
+		assert.Less(t, c.pmtuSteps[i-1].Mss, c.pmtuSteps[i].Mss)
+		assert.Less(t, c.pmtuSteps[i].Mss, uint32(pmtuSizes[i]-40))
+	}
+
+	// the echo service stops responding to the segments above 1400 bytes
+	addr := fakeServer(t, func(conn net.Conn) {
+		b := make([]byte, 9000)
+		for {
+			n, err := conn.Read(b)
+			if err != nil || n > 1400 {
+				time.Sleep(time.Second)
+				return
+			}
+			conn.Write(b[:n])
+		}
+	})
+
+	r = &request{timeout: time.Millisecond * 300, pmtu: true}
+	c = newClient(r, addr)
+	assert.NoError(t, c.connect(ctx))
+	assert.NoError(t, c.exchange())
+	assert.Equal(t, int64(1420), c.stats.PMTUMaxSize)
+	assert.Equal(t, uint8(1), c.stats.PMTUBlackhole)
+	assert.Equal(t, int64(1460), c.stats.PMTUBlackholeSize)
+	assert.False(t, c.pmtuSteps[len(c.pmtuSteps)-1].OK)
+	assert.Equal(t, pmtuAttempts, c.pmtuSteps[len(c.pmtuSteps)-1].Attempts)
+	c.close()
+
+	// no size is echoed, it's not a black hole
+	addr = fakeServer(t, func(conn net.Conn) {
+		time.Sleep(time.Second)
+	})
+
+	r = &request{timeout: time.Millisecond * 100, pmtu: true}
+	c = newClient(r, addr)
+	assert.NoError(t, c.connect(ctx))
+	assert.Error(t, c.exchange())
+	assert.Equal(t, uint8(0), c.stats.PMTUBlackhole)
+	c.close()
+}
+
+func TestTCPFastOpen(t *testing.T) {
+	ctx := context.Background()
+
+	assert.Equal(t, "", tcpOptions(0))
+	assert.Equal(t, "timestamps,sack,wscale,syn_data", tcpOptions(0x27))
+
+	b, err := ioutil.ReadFile("/proc/sys/net/ipv4/tcp_fastopen")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	// the server side TFO needs the net.ipv4.tcp_fastopen 0x2 bit
+	var mode int
+	fmt.Sscanf(string(b), "%d", &mode)
+	if mode&0x3 != 0x3 {
+		t.Skip("TCP Fast Open is not enabled for both client and server")
+	}
+
+	lc := net.ListenConfig{Control: func(network, address string, conn syscall.RawConn) error {
+		return conn.Control(func(fd uintptr) {
+			// TCP_FASTOPEN with the queue length
+			syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, 0x17, 16)
+		})
+	}}
+	ln, err := lc.Listen(ctx, "tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer ln.Close()
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				line, _ := bufio.NewReader(conn).ReadString('\n')
+				fmt.Fprint(conn, line)
+			}()
+		}
+	}()
+
+	r := &request{timeout: time.Second, soTCPFastOpen: true}
+	assert.NoError(t, r.setPayload(&payloadConfig{Send: `ping\n`, ExpectDelim: `\n`}))
+
+	c := newClient(r, ln.Addr().String())
+	synData := false
+	// the first connection fetches the cookie unless it's already cached
+	for i := 0; i < 3 && !synData; i++ {
+		assert.NoError(t, c.connect(ctx))
+		assert.NoError(t, c.exchange())
+		assert.NoError(t, c.getTCPInfo())
+		assert.Less(t, int64(0), c.TFOConnectResponse)
+		synData = c.TFOSynData == 1
+		c.close()
+	}
+
+	assert.True(t, synData)
+	assert.Contains(t, c.TCPOptions, "syn_data")
+}
+
+func TestTCPFastOpenExchange(t *testing.T) {
+	ctx := context.Background()
+
+	r := &request{soTCPFastOpen: true}
+	for target, ok := range map[string]bool{
+		"127.0.0.1:80":            false,
+		"http://127.0.0.1":        true,
+		"smtp://127.0.0.1:25":     false,
+		"ssh://127.0.0.1:22":      false,
+		"mysql://127.0.0.1:3306":  false,
+		"redis://127.0.0.1:6379":  true,
+		"tls://127.0.0.1:443":     true,
+		"grpc://127.0.0.1:50051":  true,
+		"ws://127.0.0.1/echo":     true,
+		"postgres://127.0.0.1:80": true,
+	} {
+		assert.Equal(t, ok, newClient(r, target).checkTFO() == nil, target)
+	}
+
+	// expect only payload, the server speaks first
+	r = &request{soTCPFastOpen: true, expectDelim: []byte("\n")}
+	assert.Error(t, newClient(r, "127.0.0.1:80").checkTFO())
+	r = &request{soTCPFastOpen: true, payload: []byte("ping\n"), expectDelim: []byte("\n")}
+	assert.NoError(t, newClient(r, "127.0.0.1:80").checkTFO())
+	assert.NoError(t, newClient(&request{}, "127.0.0.1:80").checkTFO())
+
+	// the connect time isn't exported for the TCP Fast Open probes
+	c := newClient(r, "127.0.0.1:1")
+	c.prometheus(ctx)
+	defer c.deprometheus(ctx)
+
+	mfs, err := prometheus.DefaultGatherer.Gather()
+	assert.NoError(t, err)
+
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "target" && l.GetValue() == c.target {
+					assert.NotEqual(t, "tp_tcp_connect", mf.GetName())
+				}
+			}
+		}
+	}
+}
+
+func TestNetns(t *testing.T) {
+	ctx := context.Background()
+
+	assert.Equal(t, "/var/run/netns/blue", netnsPath("blue"))
+	assert.Equal(t, "/proc/1/ns/net", netnsPath("/proc/1/ns/net"))
+
+	c := newClient(&request{netns: "blue"}, "127.0.0.1:80")
+	assert.Equal(t, "blue", c.labels(ctx)["netns"])
+	c = newClient(&request{}, "127.0.0.1:80")
+	assert.Equal(t, prometheus.Labels{"target": "127.0.0.1:80", "netns": "", "interface": "", "fwmark": ""}, c.labels(ctx))
+
+	name, addr := netnsServer(t, nil, func(conn net.Conn) {})
+
+	// the listener is only reachable inside the namespace
+	c = newClient(&request{timeout: time.Second, netns: name}, addr)
+	assert.NoError(t, c.connect(ctx))
+	assert.NoError(t, c.getTCPInfo())
+	c.close()
+
+	c = newClient(&request{timeout: time.Second}, addr)
+	assert.Error(t, c.connect(ctx))
+
+	// the source interface only exists inside the namespace
+	out, err := exec.Command("ip", "netns", "exec", name, "sh", "-c",
+		"ip link add tpv0 type veth peer name tpv1 && ip addr add 10.199.0.1/24 dev tpv0 && ip link set tpv0 up").CombinedOutput()
+	assert.NoError(t, err, string(out))
+
+	c = newClient(&request{timeout: time.Second, netns: name, srcAddr: "tpv0"}, addr)
+	assert.NoError(t, c.connect(ctx))
+	assert.Equal(t, "10.199.0.1", c.conn.LocalAddr().(*net.TCPAddr).IP.String())
+	c.close()
+
+	c = newClient(&request{timeout: time.Second, netns: "notfound"}, addr)
+	assert.Error(t, c.connect(ctx))
+}
+
+func TestPersistent(t *testing.T) {
+	ctx := context.Background()
+	addr := fakeServer(t, func(conn net.Conn) {
+		time.Sleep(300 * time.Millisecond)
+	})
+
+	r := &request{
+		timeout:        time.Second,
+		interval:       200 * time.Millisecond,
+		count:          4,
+		quiet:          true,
+		persistent:     true,
+		keepAliveIdle:  time.Second,
+		keepAliveIntvl: 500 * time.Millisecond,
+		keepAliveCnt:   2,
+		userTimeout:    2 * time.Second,
+	}
+
+	c := newClient(r, addr)
+	assert.NoError(t, c.connect(ctx))
+	rawConn, err := c.conn.(*net.TCPConn).SyscallConn()
+	assert.NoError(t, err)
+	rawConn.Control(func(fd uintptr) {
+		v, _ := syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_KEEPALIVE)
+		assert.Equal(t, 1, v)
+		v, _ = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE)
+		assert.Equal(t, 1, v)
+		v, _ = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_KEEPINTVL)
+		assert.Equal(t, 1, v)
+		v, _ = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_KEEPCNT)
+		assert.Equal(t, 2, v)
+		v, _ = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_TCP, 0x12)
+		assert.Equal(t, 2000, v)
+	})
+	c.close()
+
+	// the server closes the connection after 300ms
+	c = newClient(r, addr)
+	c.probe(ctx)
+	assert.Equal(t, int64(1), c.ConnDropped)
+	assert.Less(t, int64(0), c.ConnLifetime)
+	assert.Equal(t, uint8(tcpEstablished), c.State)
+
+	// the HTTP/3 connection has no TCP state
+	c = newClient(&request{persistent: true, http3: true}, "https://127.0.0.1:443")
+	assert.Error(t, c.checkPersistent())
+	c = newClient(&request{persistent: true}, "https://127.0.0.1:443")
+	assert.NoError(t, c.checkPersistent())
+}
+
+func TestMPTCP(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the plain TCP server makes the connection fall back
+	addr := fakeServer(t, func(conn net.Conn) {
+		io.Copy(conn, conn)
+	})
+
+	r := &request{timeout: time.Second, mptcp: true}
+	c := newClient(r, addr)
+	assert.NoError(t, c.connect(ctx))
+	assert.NoError(t, c.getTCPInfo())
+	assert.Equal(t, uint8(1), c.MPTCPFallback)
+	c.close()
+
+	// the MPTCP metrics are only exported for the MPTCP targets
+	f, _ := reflect.TypeOf(stats{}).FieldByName("MPTCPFallback")
+	assert.True(t, c.isExported(f))
+	assert.False(t, newClient(&request{}, addr).isExported(f))
+
+	b, err := ioutil.ReadFile("/proc/sys/net/mptcp/enabled")
+	if err != nil || strings.TrimSpace(string(b)) != "1" {
+		t.Skip("MPTCP is not enabled")
+	}
+
+	s, err := newServer(&serveReq{addr: "127.0.0.1:0", quiet: true, mptcp: true})
+	assert.NoError(t, err)
+	go s.serve(ctx)
+
+	assert.NoError(t, r.setPayload(&payloadConfig{Send: `echo ping\n`, ExpectDelim: `\n`}))
+	c = newClient(r, s.ln.Addr().String())
+	assert.NoError(t, c.connect(ctx))
+	assert.NoError(t, c.exchange())
+	assert.NoError(t, c.getTCPInfo())
+	assert.Equal(t, uint8(0), c.MPTCPFallback)
+	assert.Less(t, uint64(0), c.MPTCPBytesSent)
+	assert.Less(t, uint64(0), c.MPTCPBytesReceived)
+	c.close()
+}
+
+func TestECN(t *testing.T) {
+	ctx := context.Background()
+
+	c := &client{stats: stats{Options: tcpOptECN | tcpOptECNSeen}}
+	c.setECNStats()
+	assert.Equal(t, uint8(1), c.ECNNegotiated)
+	assert.Equal(t, uint8(1), c.ECNSeen)
+
+	// the namespace has its own net.ipv4.tcp_ecn
+	name, addr := netnsServer(t, [][]string{{"sysctl", "-w", "net.ipv4.tcp_ecn=1"}}, func(conn net.Conn) {
+		io.Copy(conn, conn)
+	})
+
+	r := &request{timeout: time.Second, netns: name, ecn: true}
+	assert.NoError(t, r.setPayload(&payloadConfig{Send: `ping`, ExpectBytes: 4}))
+
+	c = newClient(r, addr)
+	assert.NoError(t, c.connect(ctx))
+	assert.Equal(t, uint8(1), c.ECNRequested)
+	assert.NoError(t, c.exchange())
+	assert.NoError(t, c.getTCPInfo())
+	assert.Equal(t, uint8(1), c.ECNNegotiated)
+	assert.Equal(t, uint8(1), c.ECNSeen)
+	assert.Contains(t, c.TCPOptions, "ecn")
+	c.close()
+
+	// the probe fails if ECN isn't requested
+	out, err := exec.Command("ip", "netns", "exec", name, "sysctl", "-w", "net.ipv4.tcp_ecn=0").CombinedOutput()
+	assert.NoError(t, err, string(out))
+
+	c = newClient(r, addr)
+	assert.Error(t, c.connect(ctx))
+	assert.Equal(t, uint8(0), c.ECNRequested)
+}
+
+func TestTrace(t *testing.T) {
+	ctx := context.Background()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	tr, err := newTracer(&traceReq{maxHops: 3, timeout: time.Second}, ln.Addr().String())
+	assert.NoError(t, err)
+	if tr.icmp == nil {
+		t.Log("ICMP listener is not permitted, only the target's hop is tested")
+	}
+
+	var hops []traceHop
+	assert.NoError(t, tr.run(ctx, func(h traceHop) { hops = append(hops, h) }))
+	assert.Len(t, hops, 1)
+	assert.True(t, hops[0].Reached)
+	assert.False(t, hops[0].Refused)
+	assert.Equal(t, "127.0.0.1", hops[0].Addr)
+	assert.Contains(t, hops[0].String(), "[open]")
+	tr.close()
+
+	ln.Close()
+	tr, err = newTracer(&traceReq{maxHops: 3, timeout: time.Second}, ln.Addr().String())
+	assert.NoError(t, err)
+	hops = hops[:0]
+	assert.NoError(t, tr.run(ctx, func(h traceHop) { hops = append(hops, h) }))
+	assert.True(t, hops[0].Refused)
+	tr.close()
+
+	// IPv4 header with TCP protocol and the quoted TCP ports
+	quote := make([]byte, 28)
+	quote[0], quote[9] = 0x45, syscall.IPPROTO_TCP
+	binary.BigEndian.PutUint16(quote[20:], 40000)
+	binary.BigEndian.PutUint16(quote[22:], 443)
+	src, dst, ok := parseICMPQuote(quote, true)
+	assert.True(t, ok)
+	assert.Equal(t, 40000, src)
+	assert.Equal(t, 443, dst)
+
+	quote[9] = syscall.IPPROTO_UDP
+	_, _, ok = parseICMPQuote(quote, true)
+	assert.False(t, ok)
+
+	_, _, ok = parseICMPQuote(quote[:22], true)
+	assert.False(t, ok)
+}
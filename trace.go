@@ -0,0 +1,374 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/icmp"
+)
+
+// traceReq represents the TCP traceroute's parameters
+type traceReq struct {
+	maxHops int
+	timeout time.Duration
+	srcAddr string
+	ipv4    bool
+	ipv6    bool
+}
+
+// traceHop represents a TCP traceroute hop, the RTT unit is microsecond
+type traceHop struct {
+	TTL         int
+	Addr        string
+	RTT         int64
+	Reached     bool
+	Refused     bool
+	Unreachable bool
+}
+
+// tracer sends the TCP SYNs with increasing TTL to the target's
+// port and matches the ICMP replies by the SYNs source ports
+type tracer struct {
+	req     *traceReq
+	addr    string
+	ip      net.IP
+	port    int
+	icmp    *icmp.PacketConn
+	mu      sync.Mutex
+	waiters map[int]chan icmpReply
+}
+
+// icmpReply represents an ICMP time exceeded or destination
+// unreachable message which quotes one of the tracer's SYNs
+type icmpReply struct {
+	addr        string
+	at          time.Time
+	unreachable bool
+}
+
+const (
+	protocolICMP     = 1
+	protocolIPv6ICMP = 58
+)
+
+func newTracer(r *traceReq, target string) (*tracer, error) {
+	c := newClient(&request{ipv4: r.ipv4, ipv6: r.ipv6}, target)
+	addr, err := c.getAddr()
+	if err != nil {
+		return nil, err
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &tracer{
+		req:     r,
+		addr:    addr,
+		ip:      net.ParseIP(host),
+		waiters: map[int]chan icmpReply{},
+	}
+
+	if t.port, err = strconv.Atoi(port); err != nil {
+		return nil, err
+	}
+
+	network, laddr := "ip4:icmp", "0.0.0.0"
+	if !t.isIPv4() {
+		network, laddr = "ip6:ipv6-icmp", "::"
+	}
+
+	// the raw socket needs CAP_NET_RAW, the trace still
+	// finds the target's hop count without it
+	t.icmp, err = icmp.ListenPacket(network, laddr)
+	if err != nil {
+		log.Printf("ICMP listener is not permitted, the intermediate hops are not shown: %v", err)
+	} else {
+		go t.readICMP()
+	}
+
+	return t, nil
+}
+
+// run traces the target hop by hop until the target is reached
+func (t *tracer) run(ctx context.Context, fn func(traceHop)) error {
+	for ttl := 1; ttl <= t.req.maxHops; ttl++ {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		h := t.hop(ctx, ttl)
+		fn(h)
+
+		if h.Reached || h.Unreachable {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%s has not been reached in %d hops", t.addr, t.req.maxHops)
+}
+
+func (t *tracer) hop(ctx context.Context, ttl int) traceHop {
+	var (
+		h    = traceHop{TTL: ttl}
+		ch   = make(chan icmpReply, 1)
+		done = make(chan error, 1)
+		port int
+		rtt  time.Duration
+	)
+
+	ctx, cancel := context.WithTimeout(ctx, t.req.timeout)
+	defer cancel()
+
+	d := net.Dialer{Control: t.control(ttl, ch, &port)}
+
+	start := time.Now()
+	go func() {
+		conn, err := d.DialContext(ctx, "tcp", t.addr)
+		rtt = time.Since(start)
+		if err == nil {
+			conn.Close()
+		}
+		done <- err
+	}()
+
+	var (
+		err   error
+		reply *icmpReply
+	)
+
+	select {
+	case err = <-done:
+		select {
+		case r := <-ch:
+			reply = &r
+		default:
+		}
+	case r := <-ch:
+		reply = &r
+		cancel()
+		err = <-done
+	}
+
+	t.unregister(port)
+
+	switch {
+	case err == nil || errors.Is(err, syscall.ECONNREFUSED):
+		h.Addr = t.ip.String()
+		h.RTT = rtt.Microseconds()
+		h.Reached = true
+		h.Refused = err != nil
+	case reply != nil:
+		h.Addr = reply.addr
+		h.RTT = reply.at.Sub(start).Microseconds()
+		h.Unreachable = reply.unreachable
+	}
+
+	return h
+}
+
+// control sets the SYN's TTL and binds the socket to know
+// the source port before the SYN is sent
+func (t *tracer) control(ttl int, ch chan icmpReply, port *int) func(string, string, syscall.RawConn) error {
+	return func(network, address string, conn syscall.RawConn) error {
+		var err error
+
+		cErr := conn.Control(func(fd uintptr) {
+			var sa syscall.Sockaddr
+
+			src := net.ParseIP(t.req.srcAddr)
+			if t.isIPv4() {
+				err = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TTL, ttl)
+				sa4 := &syscall.SockaddrInet4{}
+				copy(sa4.Addr[:], src.To4())
+				sa = sa4
+			} else {
+				err = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_UNICAST_HOPS, ttl)
+				sa6 := &syscall.SockaddrInet6{}
+				copy(sa6.Addr[:], src.To16())
+				sa = sa6
+			}
+
+			if err != nil {
+				return
+			}
+
+			if err = syscall.Bind(int(fd), sa); err != nil {
+				return
+			}
+
+			sa, err = syscall.Getsockname(int(fd))
+			if err != nil {
+				return
+			}
+
+			switch sa := sa.(type) {
+			case *syscall.SockaddrInet4:
+				*port = sa.Port
+			case *syscall.SockaddrInet6:
+				*port = sa.Port
+			}
+
+			t.register(*port, ch)
+		})
+
+		if cErr != nil {
+			return cErr
+		}
+
+		return err
+	}
+}
+
+// readICMP reads the ICMP messages and hands over the
+// replies to the hops which are waiting for them
+func (t *tracer) readICMP() {
+	proto := protocolICMP
+	if !t.isIPv4() {
+		proto = protocolIPv6ICMP
+	}
+
+	b := make([]byte, 1500)
+	for {
+		n, peer, err := t.icmp.ReadFrom(b)
+		if err != nil {
+			return
+		}
+		at := time.Now()
+
+		msg, err := icmp.ParseMessage(proto, b[:n])
+		if err != nil {
+			continue
+		}
+
+		var (
+			data        []byte
+			unreachable bool
+		)
+
+		switch body := msg.Body.(type) {
+		case *icmp.TimeExceeded:
+			data = body.Data
+		case *icmp.DstUnreach:
+			data, unreachable = body.Data, true
+		default:
+			continue
+		}
+
+		srcPort, dstPort, ok := parseICMPQuote(data, t.isIPv4())
+		if !ok || dstPort != t.port {
+			continue
+		}
+
+		t.mu.Lock()
+		ch := t.waiters[srcPort]
+		t.mu.Unlock()
+
+		if ch != nil {
+			host := peer.String()
+			if ipAddr, ok := peer.(*net.IPAddr); ok {
+				host = ipAddr.IP.String()
+			}
+
+			select {
+			case ch <- icmpReply{addr: host, at: at, unreachable: unreachable}:
+			default:
+			}
+		}
+	}
+}
+
+func (t *tracer) register(port int, ch chan icmpReply) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.waiters[port] = ch
+}
+
+func (t *tracer) unregister(port int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.waiters, port)
+}
+
+func (t *tracer) isIPv4() bool {
+	return t.ip.To4() != nil
+}
+
+func (t *tracer) close() {
+	if t.icmp != nil {
+		t.icmp.Close()
+	}
+}
+
+// parseICMPQuote returns the TCP ports of the original
+// IP packet which is quoted in the ICMP error message
+func parseICMPQuote(b []byte, ipv4 bool) (int, int, bool) {
+	var hdrLen int
+
+	if ipv4 {
+		if len(b) < 20 || b[9] != syscall.IPPROTO_TCP {
+			return 0, 0, false
+		}
+		hdrLen = int(b[0]&0x0f) * 4
+	} else {
+		if len(b) < 40 || b[6] != syscall.IPPROTO_TCP {
+			return 0, 0, false
+		}
+		hdrLen = 40
+	}
+
+	if len(b) < hdrLen+4 {
+		return 0, 0, false
+	}
+
+	srcPort := binary.BigEndian.Uint16(b[hdrLen : hdrLen+2])
+	dstPort := binary.BigEndian.Uint16(b[hdrLen+2 : hdrLen+4])
+
+	return int(srcPort), int(dstPort), true
+}
+
+func (h traceHop) String() string {
+	if h.Addr == "" {
+		return fmt.Sprintf("%2d  *", h.TTL)
+	}
+
+	s := fmt.Sprintf("%2d  %s  %.3f ms", h.TTL, h.Addr, float64(h.RTT)/1000)
+
+	switch {
+	case h.Refused:
+		s += "  [closed] TCPConnect:" + strconv.FormatInt(h.RTT, 10)
+	case h.Reached:
+		s += "  [open] TCPConnect:" + strconv.FormatInt(h.RTT, 10)
+	case h.Unreachable:
+		s += "  !unreachable"
+	}
+
+	return s
+}
+
+// trace runs the TCP traceroute to the targets one by one
+func trace(ctx context.Context, r *traceReq, targets []string) {
+	for _, target := range targets {
+		t, err := newTracer(r, target)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+
+		fmt.Printf("tcp traceroute to %s (%s), %d hops max\n", target, t.addr, r.maxHops)
+		if err := t.run(ctx, func(h traceHop) { fmt.Println(h) }); err != nil {
+			log.Println(err)
+		}
+
+		t.close()
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	wsOpClose = 0x8
+	wsOpPing  = 0x9
+	wsOpPong  = 0xa
+
+	wsMaxFrame = 1 << 20
+)
+
+// isWebSocket returns true if the target is a WebSocket, ws:// or wss://
+func (c *client) isWebSocket() bool {
+	return c.scheme() == "ws" || c.scheme() == "wss"
+}
+
+// webSocket performs the upgrade handshake and then
+// measures the round trip time of the ping frames
+func (c *client) webSocket() error {
+	var conn net.Conn = c.conn
+
+	c.stats.WSUpgrade = 0
+	c.stats.WSPingMin = 0
+	c.stats.WSPingAvg = 0
+	c.stats.WSPingMax = 0
+
+	if c.scheme() == "wss" {
+		config := c.tlsConfig(c.serverName())
+		config.NextProtos = []string{"http/1.1"}
+
+		tlsConn, err := c.tlsHandshake(c.conn, config)
+		if err != nil {
+			return err
+		}
+
+		c.closers = append(c.closers, tlsConn)
+		conn = tlsConn
+	}
+
+	conn.SetDeadline(time.Now().Add(c.req.timeout))
+	defer conn.SetDeadline(time.Time{})
+
+	r := bufio.NewReader(conn)
+
+	if err := c.wsUpgrade(conn, r); err != nil {
+		c.stats.WSError++
+		return fmt.Errorf("%s websocket: %v", c.target, err)
+	}
+
+	if err := c.wsPing(conn, r); err != nil {
+		c.stats.WSError++
+		return fmt.Errorf("%s websocket: %v", c.target, err)
+	}
+
+	// normal closure
+	wsWriteFrame(conn, wsOpClose, []byte{0x03, 0xe8})
+
+	return nil
+}
+
+func (c *client) wsUpgrade(conn net.Conn, r *bufio.Reader) error {
+	u := *c.urlSchema
+	u.Scheme = "http"
+	if c.scheme() == "wss" {
+		u.Scheme = "https"
+	}
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	b := make([]byte, 16)
+	rand.Read(b)
+	key := base64.StdEncoding.EncodeToString(b)
+
+	for k, v := range c.req.httpHeaders {
+		req.Header[k] = v
+	}
+	if host := req.Header.Get("Host"); host != "" {
+		req.Host = host
+	}
+
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	if req.Header.Get("Origin") == "" {
+		req.Header.Set("Origin", u.Scheme+"://"+u.Host)
+	}
+
+	t := time.Now()
+	if err := req.Write(conn); err != nil {
+		return err
+	}
+
+	resp, err := http.ReadResponse(r, req)
+	if err != nil {
+		return err
+	}
+	c.stats.WSUpgrade = time.Since(t).Microseconds()
+	c.stats.HTTPStatusCode = resp.StatusCode
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	h := sha1.Sum([]byte(key + wsGUID))
+	if resp.Header.Get("Sec-WebSocket-Accept") != base64.StdEncoding.EncodeToString(h[:]) {
+		return errors.New("invalid Sec-WebSocket-Accept")
+	}
+
+	return nil
+}
+
+func (c *client) wsPing(conn net.Conn, r *bufio.Reader) error {
+	var sum int64
+
+	for i := 0; i < c.req.wsPings; i++ {
+		payload := make([]byte, 8)
+		binary.BigEndian.PutUint64(payload, uint64(i))
+
+		t := time.Now()
+		if err := wsWriteFrame(conn, wsOpPing, payload); err != nil {
+			return err
+		}
+
+		// skip the data frames until the pong
+		for {
+			opcode, b, err := wsReadFrame(r)
+			if err != nil {
+				return err
+			}
+
+			if opcode == wsOpClose {
+				return errors.New("connection closed by server")
+			}
+
+			if opcode == wsOpPong && string(b) == string(payload) {
+				break
+			}
+		}
+
+		rtt := time.Since(t).Microseconds()
+		if i == 0 || rtt < c.stats.WSPingMin {
+			c.stats.WSPingMin = rtt
+		}
+		if rtt > c.stats.WSPingMax {
+			c.stats.WSPingMax = rtt
+		}
+		sum += rtt
+		c.stats.WSPingAvg = sum / int64(i+1)
+	}
+
+	return nil
+}
+
+// wsWriteFrame writes a final and masked frame as a client
+func wsWriteFrame(w io.Writer, opcode byte, payload []byte) error {
+	mask := make([]byte, 4)
+	rand.Read(mask)
+
+	frame := []byte{0x80 | opcode}
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, 0x80|byte(len(payload)))
+	case len(payload) <= 0xffff:
+		frame = append(frame, 0x80|126, byte(len(payload)>>8), byte(len(payload)))
+	default:
+		frame = append(frame, 0x80|127)
+		frame = append(frame, make([]byte, 8)...)
+		binary.BigEndian.PutUint64(frame[2:], uint64(len(payload)))
+	}
+
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+
+	_, err := w.Write(frame)
+
+	return err
+}
+
+func wsReadFrame(r *bufio.Reader) (byte, []byte, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		b := make([]byte, 2)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(b))
+	case 127:
+		b := make([]byte, 8)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(b)
+	}
+
+	if length > wsMaxFrame {
+		return 0, nil, fmt.Errorf("frame exceeded %d bytes", wsMaxFrame)
+	}
+
+	mask := make([]byte, 4)
+	if header[1]&0x80 != 0 {
+		if _, err := io.ReadFull(r, mask); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+
+	return header[0] & 0x0f, payload, nil
+}